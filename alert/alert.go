@@ -0,0 +1,65 @@
+// Package alert turns scored signatures into Red/Orange/Yellow feeder alerts. Port of
+// AlertGenerator and _Alert in python/alert.py.
+package alert
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "pam/lib"
+)
+
+// Alert holds the details of one alert to send
+type Alert struct {
+    FeederId       string
+    Timestamp      int64          // UTC epoch of the signature that raised the alert
+    AlertType      string         // alert level name, e.g. Red
+    ModelId        string
+    Anomalies      []lib.Anomaly  // anomalies that caused the alert, renamed by the anomaly map
+    FeederMetadata []MetadataItem // human readable feeder details, in report order
+}
+
+type MetadataItem struct {
+    Label string
+    Value string
+}
+
+func (a *Alert) String() string {
+    return fmt.Sprintf("%s Alert: Feeder %s @ %s", a.AlertType, a.FeederId, time.Unix(a.Timestamp, 0).UTC())
+}
+
+// Feeder metadata columns reported with an alert and their labels
+var metadataColumns = []struct{ column, label string }{
+    {"CEMM35_FEEDER", "CEMM25 Feeder"}, {"4N+_FEEDER", "4N+ Feeder"}, {"FEEDER", "Feeder"},
+    {"REGION", "Region"}, {"AREA", "Area"}, {"SUBSTATION", "Substation"}, {"KV", "KV"},
+    {"CUSTOMERS", "Customers"}, {"FDR_OH", "Feeder OH"}, {"FDR_UG", "Feeder UG"},
+    {"LAT_OH", "Lateral OH"}, {"LAT_UG", "Lateral UG"}, {"HARDENING", "Hardening"},
+}
+
+// Hardening dates before this are reported as None
+var hardeningCutoff = time.Date(1985, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// feederMetadata formats the metadata of a feeder for an alert report
+func feederMetadata(feeder lib.Feeder) []MetadataItem {
+    var items []MetadataItem
+    for _, column := range metadataColumns {
+        value := strings.TrimSpace(feeder.Metadata[column.column])
+        switch column.column {
+        case "FDR_OH", "FDR_UG", "LAT_OH", "LAT_UG":
+            if miles, err := strconv.ParseFloat(value, 64); err == nil {
+                value = fmt.Sprintf("%.1f mi", miles)
+            }
+        case "HARDENING":
+            tm, err := time.Parse("2006-01-02 15:04:05-07:00", value)
+            if err != nil || tm.Before(hardeningCutoff) {
+                value = "None"
+            } else {
+                value = tm.Format("2006-01-02")
+            }
+        }
+        items = append(items, MetadataItem{Label: column.label, Value: value})
+    }
+    return items
+}
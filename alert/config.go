@@ -0,0 +1,51 @@
+package alert
+
+import (
+    "fmt"
+    "io/ioutil"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Config holds the alert levels of a model (data/pam_<version>_alert.yaml). Name[i] is the level of
+// probabilities in [Value[i-1], Value[i]), the first level starting at 0. A level named None sends no alert.
+type Config struct {
+    Name  []string  `yaml:"Name"`
+    Value []float64 `yaml:"Value"`
+}
+
+// LoadConfig reads an alert config and checks that the thresholds increase
+func LoadConfig(fileName string) (*Config, error) {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return nil, err
+    }
+    config := new(Config)
+    if err = yaml.Unmarshal(data, config); err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+    if len(config.Name) == 0 || len(config.Name) != len(config.Value) {
+        return nil, fmt.Errorf("%s: Name and Value must list the same, non-zero number of levels", fileName)
+    }
+    lower := 0.0
+    for i, upper := range config.Value {
+        if upper <= lower {
+            return nil, fmt.Errorf("%s: threshold %g of %s is not above %g", fileName, upper, config.Name[i], lower)
+        }
+        lower = upper
+    }
+    return config, nil
+}
+
+// Level returns the alert name and tier (the lower threshold) of a probability. Probabilities at or
+// above the last threshold get a blank name and tier 0, as in python/alert.py.
+func (c *Config) Level(prob float64) (string, float64) {
+    lower := 0.0
+    for i, upper := range c.Value {
+        if prob >= lower && prob < upper {
+            return c.Name[i], lower
+        }
+        lower = upper
+    }
+    return " ", 0
+}
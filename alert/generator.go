@@ -0,0 +1,276 @@
+package alert
+
+import (
+    "fmt"
+    "sort"
+    "time"
+
+    "pam/lib"
+)
+
+const DAY int64 = 24 * lib.HOUR
+
+// Columns set to every combination of their boolean values at prediction time, keeping the highest
+// probability (see deprecatedColumns in lib/signature.go)
+var geoColumns = []string{"FDR_GEO_0", "FDR_GEO_1", "FDR_GEO_2"}
+
+// Generator decides which feeders get an alert from their anomalies. Run Transform and Predict (or
+// SetPredictions with the output of an external model), then GenerateAlerts.
+type Generator struct {
+    Dataset     []lib.DatasetObject
+    AnomalyMap  *lib.AnomalyMap
+    FeederMap   map[string]lib.Feeder
+    Config      *Config
+    ModelId     string
+    StartTime   int64 // earliest signature time to predict, 0 for no limit
+    EndTime     int64 // anomalies at or after EndTime are ignored, 0 for no limit
+    Signatures  *lib.SignatureTransformer
+    Predictions []Prediction
+    Alerts      []Alert
+}
+
+func NewGenerator(dataset []lib.DatasetObject, anomalyMap *lib.AnomalyMap, feederMap map[string]lib.Feeder,
+    config *Config, modelId string) *Generator {
+    return &Generator{Dataset: dataset, AnomalyMap: anomalyMap, FeederMap: feederMap, Config: config, ModelId: modelId}
+}
+
+// Transform builds the signatures of the feeders with unprocessed anomalies. processed (nil on the
+// first run) holds the anomalies of earlier runs that still fall in the signature windows.
+func (g *Generator) Transform(unprocessed map[string][]lib.Anomaly, processed map[string][]lib.Anomaly) error {
+    transformer, err := lib.NewSignatureTransformer(g.Dataset, g.AnomalyMap, g.FeederMap)
+    if err != nil {
+        return err
+    }
+    anomalies := make(map[string][]lib.Anomaly)
+    for feederId, fAnomalies := range unprocessed {
+        var fCombined []lib.Anomaly
+        for _, anomaly := range append(append([]lib.Anomaly{}, fAnomalies...), processed[feederId]...) {
+            if g.EndTime == 0 || minute(anomaly.EpochTime) < g.EndTime {
+                fCombined = append(fCombined, anomaly)
+            }
+        }
+        anomalies[feederId] = g.dropAMIDuplicates(fCombined, true)
+    }
+    transformer.Transform(anomalies)
+
+    // keep the rows from StartTime on
+    var x []lib.XObject
+    var y []lib.YObject
+    for i, yObj := range transformer.Y {
+        if yObj.Timestamp >= g.StartTime {
+            x = append(x, transformer.X[i])
+            y = append(y, yObj)
+        }
+    }
+    transformer.X, transformer.Y = x, y
+    g.Signatures = transformer
+    return nil
+}
+
+// Predict scores every signature. When the dataset has the deprecated IS_DADE, SIG_OUTLIER and
+// FDR_GEO_* columns, every combination of their values is scored and the highest probability kept.
+func (g *Generator) Predict(scorer lib.Scorer) error {
+    if g.Signatures == nil {
+        return fmt.Errorf("run Transform before Predict")
+    }
+    columns := make(map[string]int)
+    for i, datasetObj := range g.Dataset {
+        columns[datasetObj.Name] = i
+    }
+    combine := true
+    for _, name := range append([]string{"IS_DADE", "SIG_OUTLIER"}, geoColumns...) {
+        _, ok := columns[name]
+        combine = combine && ok
+    }
+
+    var preds []Prediction
+    for i, yObj := range g.Signatures.Y {
+        values := append([]float64{}, g.Signatures.X[i].Values...)
+        var prob float64
+        var err error
+        if combine {
+            prob, err = g.scoreCombinations(scorer, values, columns)
+        } else {
+            prob, err = scorer.Score(values)
+        }
+        if err != nil {
+            return fmt.Errorf("feeder %s @ %s: %v", yObj.Feeder, time.Unix(yObj.Timestamp, 0).UTC(), err)
+        }
+        preds = append(preds, Prediction{Feeder: yObj.Feeder, Timestamp: yObj.Timestamp, Prob: prob})
+    }
+    g.SetPredictions(preds)
+    return nil
+}
+
+func (g *Generator) scoreCombinations(scorer lib.Scorer, values []float64, columns map[string]int) (float64, error) {
+    maxProb := 0.0
+    for isDade := 0; isDade <= 1; isDade++ {
+        for sigOutlier := 0; sigOutlier <= 1; sigOutlier++ {
+            for _, fdrGeo := range geoColumns {
+                values[columns["IS_DADE"]]     = float64(isDade)
+                values[columns["SIG_OUTLIER"]] = float64(sigOutlier)
+                for _, name := range geoColumns {
+                    values[columns[name]] = 0
+                }
+                values[columns[fdrGeo]] = 1
+                prob, err := scorer.Score(values)
+                if err != nil {
+                    return 0, err
+                }
+                if prob > maxProb {
+                    maxProb = prob
+                }
+            }
+        }
+    }
+    return maxProb, nil
+}
+
+// SetPredictions keeps the highest probability of each feeder and time and sets its alert level
+func (g *Generator) SetPredictions(preds []Prediction) {
+    g.Predictions = nil
+    index := make(map[string]int)
+    for _, pred := range preds {
+        key := fmt.Sprintf("%s|%d", pred.Feeder, pred.Timestamp)
+        if i, ok := index[key]; ok {
+            if pred.Prob > g.Predictions[i].Prob {
+                g.Predictions[i].Prob = pred.Prob
+            }
+            continue
+        }
+        index[key] = len(g.Predictions)
+        g.Predictions = append(g.Predictions, Prediction{Feeder: pred.Feeder, Timestamp: pred.Timestamp, Prob: pred.Prob})
+    }
+    for i := range g.Predictions {
+        g.Predictions[i].Alert, g.Predictions[i].Tier = g.Config.Level(g.Predictions[i].Prob)
+    }
+}
+
+// GenerateAlerts creates an alert for every prediction that raises the highest tier of its feeder
+// over the prior 24 hours, unless oldPreds (predictions of earlier runs, may be nil) already reached
+// that tier within a day of it. Each alert holds the trigger, flag and background anomalies of its
+// signature.
+func (g *Generator) GenerateAlerts(unprocessed map[string][]lib.Anomaly, processed map[string][]lib.Anomaly,
+    oldPreds []Prediction) error {
+    if g.Predictions == nil {
+        return fmt.Errorf("run Predict or SetPredictions before GenerateAlerts")
+    }
+    fAlerts := make(map[string][]Prediction)
+    for _, pred := range g.Predictions {
+        if pred.Alert != "None" {
+            fAlerts[pred.Feeder] = append(fAlerts[pred.Feeder], pred)
+        }
+    }
+    var feederIds []string
+    for feederId := range fAlerts {
+        feederIds = append(feederIds, feederId)
+    }
+    sort.Strings(feederIds)
+
+    g.Alerts = nil
+    for _, feederId := range feederIds {
+        feeder, ok := g.FeederMap[feederId]
+        if !ok {
+            return fmt.Errorf("feeder %s has predictions but no metadata", feederId)
+        }
+        fAnomalies := g.cleanAnomalies(append(append([]lib.Anomaly{}, unprocessed[feederId]...), processed[feederId]...))
+        metadata   := feederMetadata(feeder)
+        preds      := fAlerts[feederId]
+        sort.SliceStable(preds, func(i, j int) bool { return preds[i].Timestamp < preds[j].Timestamp })
+        for _, pred := range preds {
+            // only alert when the signature reaches a new high water mark for the prior 24 hours
+            priorTier := 0.0
+            for _, prior := range preds {
+                if prior.Timestamp < pred.Timestamp && prior.Timestamp >= pred.Timestamp - DAY && prior.Tier > priorTier {
+                    priorTier = prior.Tier
+                }
+            }
+            if pred.Tier <= priorTier || alreadySent(oldPreds, pred) {
+                continue
+            }
+            g.Alerts = append(g.Alerts, Alert{
+                FeederId:       feederId,
+                Timestamp:      pred.Timestamp,
+                AlertType:      pred.Alert,
+                ModelId:        g.ModelId,
+                Anomalies:      g.signatureAnomalies(fAnomalies, pred.Timestamp),
+                FeederMetadata: metadata,
+            })
+        }
+    }
+    return nil
+}
+
+// alreadySent reports whether an earlier run predicted pred's tier or higher within a day of it
+func alreadySent(oldPreds []Prediction, pred Prediction) bool {
+    for _, old := range oldPreds {
+        if old.Feeder == pred.Feeder && old.Tier >= pred.Tier &&
+            old.Timestamp < pred.Timestamp + DAY && old.Timestamp >= pred.Timestamp - DAY {
+            return true
+        }
+    }
+    return false
+}
+
+// signatureAnomalies returns the anomalies counted by the trigger, flag and background columns of the
+// signature at t, column by column
+func (g *Generator) signatureAnomalies(fAnomalies []lib.Anomaly, t int64) []lib.Anomaly {
+    var anomalies []lib.Anomaly
+    for _, datasetObj := range g.Dataset {
+        switch datasetObj.Type {
+        case "trigger", "flag", "background":
+            minLagTime := t - datasetObj.MinLag * lib.HOUR
+            maxLagTime := t - datasetObj.MaxLag * lib.HOUR
+            for _, anomaly := range fAnomalies {
+                if anomaly.Anomaly == datasetObj.Lookup && anomaly.EpochTime <= minLagTime && anomaly.EpochTime > maxLagTime {
+                    anomalies = append(anomalies, anomaly)
+                }
+            }
+        }
+    }
+    return anomalies
+}
+
+// cleanAnomalies truncates times to the minute, renames anomalies with the anomaly map, drops those
+// the model does not use or at or after EndTime, then drops duplicate AMI anomalies
+func (g *Generator) cleanAnomalies(fAnomalies []lib.Anomaly) []lib.Anomaly {
+    var cleaned []lib.Anomaly
+    for _, anomaly := range fAnomalies {
+        name, ok := g.AnomalyMap.Lookup(anomaly.Anomaly)
+        t        := minute(anomaly.EpochTime)
+        if !ok || (g.EndTime != 0 && t >= g.EndTime) {
+            continue
+        }
+        anomaly.Anomaly   = name
+        anomaly.EpochTime = t
+        anomaly.Time      = time.Unix(t, 0).UTC().Format(lib.AnomalyTimeFormat)
+        cleaned = append(cleaned, anomaly)
+    }
+    return g.dropAMIDuplicates(cleaned, false)
+}
+
+// dropAMIDuplicates drops AMI anomalies repeating an anomaly of the same renamed name (rename
+// anomalies not renamed yet), feeder and minute. AMI last gasps / power downs can come in slowly
+// over time; sorting by Signal keeps the highest.
+func (g *Generator) dropAMIDuplicates(fAnomalies []lib.Anomaly, rename bool) []lib.Anomaly {
+    sorted := append([]lib.Anomaly{}, fAnomalies...)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Signal > sorted[j].Signal })
+    seen := make(map[string]bool)
+    var kept []lib.Anomaly
+    for _, anomaly := range sorted {
+        name := anomaly.Anomaly
+        if rename {
+            name, _ = g.AnomalyMap.Lookup(anomaly.Anomaly)
+        }
+        key := fmt.Sprintf("%s|%s|%d", name, anomaly.FeederId, minute(anomaly.EpochTime))
+        if anomaly.DeviceType != "AMI" || !seen[key] {
+            kept = append(kept, anomaly)
+        }
+        seen[key] = true
+    }
+    return kept
+}
+
+func minute(epochTime int64) int64 {
+    return epochTime - epochTime % 60
+}
@@ -0,0 +1,112 @@
+package alert
+
+import (
+    "encoding/csv"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+
+    "pam/lib"
+)
+
+// Prediction is the outage probability and alert level of one signature row
+type Prediction struct {
+    Feeder    string
+    Timestamp int64
+    Prob      float64
+    Alert     string
+    Tier      float64
+}
+
+var predictionColumns = []string{"FEEDER", "TIMESTAMP", "PROB", "ALERT", "TIER"}
+
+// ReadPredictions reads a predictions CSV: one written by WritePredictions or the output of an
+// external model, which only needs the FEEDER, TIMESTAMP (lib.SignatureTimeFormat, UTC) and PROB
+// columns. ALERT and TIER are read when present.
+func ReadPredictions(fileName string) ([]Prediction, error) {
+    file, err := os.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    r           := csv.NewReader(file)
+    header, err := r.Read()
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+    index := make(map[string]int)
+    for i, column := range header {
+        index[column] = i
+    }
+    for _, column := range predictionColumns[:3] {
+        if _, ok := index[column]; !ok {
+            return nil, fmt.Errorf("%s: missing column %s", fileName, column)
+        }
+    }
+
+    var preds []Prediction
+    for line := 2; ; line++ {
+        record, err := r.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("%s: %v", fileName, err)
+        }
+        tm, err := time.Parse(lib.SignatureTimeFormat, record[index["TIMESTAMP"]])
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d: TIMESTAMP: %v", fileName, line, err)
+        }
+        pred := Prediction{Feeder: record[index["FEEDER"]], Timestamp: tm.Unix()}
+        if pred.Prob, err = strconv.ParseFloat(record[index["PROB"]], 64); err != nil {
+            return nil, fmt.Errorf("%s:%d: PROB: %v", fileName, line, err)
+        }
+        if i, ok := index["ALERT"]; ok {
+            pred.Alert = record[i]
+        }
+        if i, ok := index["TIER"]; ok {
+            if pred.Tier, err = strconv.ParseFloat(record[i], 64); err != nil {
+                return nil, fmt.Errorf("%s:%d: TIER: %v", fileName, line, err)
+            }
+        }
+        preds = append(preds, pred)
+    }
+    return preds, nil
+}
+
+// WritePredictions writes preds with the columns read by ReadPredictions
+func WritePredictions(fileName string, preds []Prediction) error {
+    if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+        return err
+    }
+    file, err := os.Create(fileName)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    w := csv.NewWriter(file)
+    if err = w.Write(predictionColumns); err != nil {
+        return err
+    }
+    for _, pred := range preds {
+        record := []string{
+            pred.Feeder,
+            time.Unix(pred.Timestamp, 0).UTC().Format(lib.SignatureTimeFormat),
+            strconv.FormatFloat(pred.Prob, 'g', -1, 64),
+            pred.Alert,
+            strconv.FormatFloat(pred.Tier, 'g', -1, 64),
+        }
+        if err = w.Write(record); err != nil {
+            return err
+        }
+    }
+    w.Flush()
+    if err = w.Error(); err != nil {
+        return err
+    }
+    return file.Close()
+}
@@ -0,0 +1,172 @@
+package alert
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "pam/lib"
+)
+
+// Options of ProcessAlerts beyond the run config
+type Options struct {
+    ConfigFile         string // alert levels, default <data_dir>/pam_<dataset_version>_alert.yaml
+    ModelId            string // reported with each alert, default pam_<dataset_version>
+    ProcessedFile      string // anomalies of earlier runs, optional
+    ModelFile          string // model scoring the signatures (see lib.LoadScorer)
+    PredictionsFile    string // or predictions of an external model (see ReadPredictions)
+    OldPredictionsFile string // predictions of earlier runs, optional
+    HTMLTemplate       string // overrides DefaultHTMLTemplate, optional
+    StartTime          int64  // 0 for no limit
+    EndTime            int64  // 0 for no limit
+}
+
+// ProcessAlerts generates the alerts of the anomalies in input.anomalies_file and writes the predictions
+// to <output_dir>/predictions_<model id>.csv, the input rows that could not be read to
+// <output_dir>/quarantine_<model id>.csv and each alert as JSON and HTML to <output_dir>/alerts.
+// It returns the alerts.
+func ProcessAlerts(cfg *lib.Config, options Options) ([]Alert, error) {
+    if err := cfg.Require("feeder_metadata", "data_dir", "dataset_version", "anomaly_map_version",
+        "input.anomalies_file", "output_dir"); err != nil {
+        return nil, err
+    }
+    if options.ConfigFile == "" {
+        options.ConfigFile = cfg.DataPath("alert", cfg.DatasetVersion, ".yaml")
+    }
+    if options.ModelId == "" {
+        options.ModelId = "pam_" + cfg.DatasetVersion
+    }
+    if (options.ModelFile == "") == (options.PredictionsFile == "") {
+        return nil, fmt.Errorf("give either a model file or a predictions file")
+    }
+
+    config, err := LoadConfig(options.ConfigFile)
+    if err != nil {
+        return nil, err
+    }
+    renderer, err := NewRenderer()
+    if err != nil {
+        return nil, err
+    }
+    if options.HTMLTemplate != "" {
+        if err = renderer.LoadTemplate(options.HTMLTemplate); err != nil {
+            return nil, err
+        }
+    }
+    anomalyMap, err := lib.GetAnomalyMap(cfg.DataDir, cfg.AnomalyMapVersion)
+    if err != nil {
+        return nil, err
+    }
+    dataset, err := lib.GetDataset(cfg.DataDir, cfg.DatasetVersion)
+    if err != nil {
+        return nil, err
+    }
+    if unused := anomalyMap.UnusedTargets(dataset); len(unused) > 0 {
+        fmt.Printf("%s: ignoring targets no dataset column looks up: %s\n", anomalyMap.FileName, strings.Join(unused, ", "))
+    }
+    maxBadRows, err := cfg.BadRowLimit()
+    if err != nil {
+        return nil, err
+    }
+    feederBad := lib.NewBadRows(maxBadRows)
+    feederMap, err := lib.GetFeederMap(cfg.FeederMetadata, feederBad)
+    if err != nil {
+        return nil, err
+    }
+    generator := NewGenerator(dataset, anomalyMap, feederMap, config, options.ModelId)
+    generator.StartTime, generator.EndTime = options.StartTime, options.EndTime
+
+    unprocessedBad := lib.NewBadRows(maxBadRows)
+    unprocessed, err := lib.GetAnomalies(cfg.Input.AnomaliesFile, unprocessedBad)
+    if err != nil {
+        return nil, err
+    }
+    var processed map[string][]lib.Anomaly
+    processedBad := lib.NewBadRows(maxBadRows)
+    if options.ProcessedFile != "" {
+        if processed, err = lib.GetAnomalies(options.ProcessedFile, processedBad); err != nil {
+            return nil, err
+        }
+    }
+    var badRows []*lib.RowError
+    for _, input := range []struct {
+        fileName string
+        bad      *lib.BadRows
+    }{{cfg.FeederMetadata, feederBad}, {cfg.Input.AnomaliesFile, unprocessedBad}, {options.ProcessedFile, processedBad}} {
+        if input.bad.Count() > 0 {
+            fmt.Println(input.bad.Format(input.fileName))
+        }
+        badRows = append(badRows, input.bad.Rows...)
+    }
+    if options.ModelFile != "" {
+        scorer, err := lib.LoadScorer(options.ModelFile, dataset)
+        if err != nil {
+            return nil, err
+        }
+        if err = generator.Transform(unprocessed, processed); err != nil {
+            return nil, err
+        }
+        if err = generator.Predict(scorer); err != nil {
+            return nil, err
+        }
+    } else {
+        preds, err := ReadPredictions(options.PredictionsFile)
+        if err != nil {
+            return nil, err
+        }
+        generator.SetPredictions(preds)
+    }
+    var oldPreds []Prediction
+    if options.OldPredictionsFile != "" {
+        if oldPreds, err = ReadPredictions(options.OldPredictionsFile); err != nil {
+            return nil, err
+        }
+    }
+    if err = generator.GenerateAlerts(unprocessed, processed, oldPreds); err != nil {
+        return nil, err
+    }
+
+    predsFile := cfg.OutputPath("predictions_" + options.ModelId + ".csv")
+    if err = WritePredictions(predsFile, generator.Predictions); err != nil {
+        return nil, err
+    }
+    fmt.Printf("Wrote %d predictions to %s\n", len(generator.Predictions), predsFile)
+    quarantineFile := cfg.OutputPath("quarantine_" + options.ModelId + ".csv")
+    if err = lib.WriteQuarantine(quarantineFile, badRows); err != nil {
+        return nil, err
+    }
+    fmt.Printf("Wrote %d bad input rows to %s\n", len(badRows), quarantineFile)
+    if err = writeReports(renderer, cfg.OutputPath("alerts"), generator.Alerts); err != nil {
+        return nil, err
+    }
+    return generator.Alerts, nil
+}
+
+// writeReports writes alert_<feeder>_<UTC time>.json and .html for every alert to dirName
+func writeReports(renderer *Renderer, dirName string, alerts []Alert) error {
+    if err := os.MkdirAll(dirName, 0755); err != nil {
+        return err
+    }
+    for i := range alerts {
+        baseName := filepath.Join(dirName, fmt.Sprintf("alert_%s_%s", alerts[i].FeederId,
+            time.Unix(alerts[i].Timestamp, 0).UTC().Format("20060102T150405Z")))
+        payload, err := renderer.JSON(&alerts[i])
+        if err != nil {
+            return err
+        }
+        if err = ioutil.WriteFile(baseName + ".json", payload, 0644); err != nil {
+            return err
+        }
+        html, err := renderer.HTML(&alerts[i])
+        if err != nil {
+            return err
+        }
+        if err = ioutil.WriteFile(baseName + ".html", []byte(html), 0644); err != nil {
+            return err
+        }
+    }
+    return nil
+}
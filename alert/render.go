@@ -0,0 +1,210 @@
+package alert
+
+import (
+    "bytes"
+    "encoding/json"
+    "html/template"
+    "io/ioutil"
+    "sort"
+    "time"
+)
+
+const alertTimeFormat = "2006-01-02T15:04:05Z"
+
+// Colors of the alert level cell in HTML reports
+var alertColors = map[string]string{"Red": "#F78181", "Orange": "#F7BE81", "Yellow": "#F3F781"}
+
+// AlertPayload is the JSON form of an alert, as produced by _Alert.to_dict in python/alert.py
+type AlertPayload struct {
+    FeederId     string       `json:"feeder_id"`
+    AlertType    string       `json:"alert_type"`
+    ModelVersion string       `json:"model_version"`
+    AlertTimeUTC string       `json:"alert_time_utc"`
+    AlertSentUTC string       `json:"alert_sent_utc"`
+    Anomalies    []AnomalyRow `json:"anomalies"`
+}
+
+// AnomalyRow is one anomaly of a rendered alert. Time is UTC in JSON and US/Eastern in HTML.
+type AnomalyRow struct {
+    Anomaly    string `json:"Anomaly"`
+    DeviceId   string `json:"DeviceId"`
+    DevicePh   string `json:"DevicePh"`
+    DeviceType string `json:"DeviceType"`
+    Signal     string `json:"Signal"`
+    Time       string `json:"Time"`
+}
+
+// HTMLData is passed to the HTML template. Times are US/Eastern with an EST/EDT label.
+type HTMLData struct {
+    FeederId   string
+    AlertType  string
+    AlertColor string // background of the alert level cell, empty for unknown levels
+    ModelId    string
+    Time       string
+    Metadata   []MetadataItem
+    Anomalies  []AnomalyRow // sorted by time, then anomaly
+}
+
+// Renderer renders alerts as JSON payloads and HTML emails, like _Alert.to_dict and _Alert.to_html
+type Renderer struct {
+    Template *template.Template
+    Location *time.Location // time zone of the HTML report
+    Now      func() time.Time
+}
+
+// NewRenderer returns a renderer with the default HTML template and US/Eastern times
+func NewRenderer() (*Renderer, error) {
+    location, err := time.LoadLocation("America/New_York")
+    if err != nil {
+        return nil, err
+    }
+    return &Renderer{Template: template.Must(template.New("alert").Parse(DefaultHTMLTemplate)), Location: location, Now: time.Now}, nil
+}
+
+// LoadTemplate replaces the HTML template with an html/template file executed with HTMLData
+func (r *Renderer) LoadTemplate(fileName string) error {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return err
+    }
+    tmpl, err := template.New(fileName).Parse(string(data))
+    if err != nil {
+        return err
+    }
+    r.Template = tmpl
+    return nil
+}
+
+// Payload returns the JSON form of an alert, sent now
+func (r *Renderer) Payload(a *Alert) AlertPayload {
+    payload := AlertPayload{
+        FeederId:     a.FeederId,
+        AlertType:    a.AlertType,
+        ModelVersion: a.ModelId,
+        AlertTimeUTC: time.Unix(a.Timestamp, 0).UTC().Format(alertTimeFormat),
+        AlertSentUTC: r.Now().UTC().Format(alertTimeFormat),
+        Anomalies:    make([]AnomalyRow, 0, len(a.Anomalies)),
+    }
+    for _, anomaly := range a.Anomalies {
+        payload.Anomalies = append(payload.Anomalies, anomalyRow(anomaly.Anomaly, anomaly.DeviceId, anomaly.DevicePhase,
+            anomaly.DeviceType, anomaly.Signal, time.Unix(anomaly.EpochTime, 0).UTC().Format(alertTimeFormat)))
+    }
+    return payload
+}
+
+// JSON returns the indented JSON payload of an alert, ending in a newline
+func (r *Renderer) JSON(a *Alert) ([]byte, error) {
+    var buf bytes.Buffer
+    encoder := json.NewEncoder(&buf)
+    encoder.SetEscapeHTML(false)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(r.Payload(a)); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// HTML returns the HTML report of an alert, rendered with Template
+func (r *Renderer) HTML(a *Alert) (string, error) {
+    data := HTMLData{
+        FeederId:   a.FeederId,
+        AlertType:  a.AlertType,
+        AlertColor: alertColors[a.AlertType],
+        ModelId:    a.ModelId,
+        Time:       r.localTime(a.Timestamp),
+        Metadata:   a.FeederMetadata,
+    }
+    anomalies := append(a.Anomalies[:0:0], a.Anomalies...)
+    sort.SliceStable(anomalies, func(i, j int) bool {
+        if anomalies[i].EpochTime != anomalies[j].EpochTime {
+            return anomalies[i].EpochTime < anomalies[j].EpochTime
+        }
+        return anomalies[i].Anomaly < anomalies[j].Anomaly
+    })
+    for _, anomaly := range anomalies {
+        data.Anomalies = append(data.Anomalies, anomalyRow(anomaly.Anomaly, anomaly.DeviceId, anomaly.DevicePhase,
+            anomaly.DeviceType, anomaly.Signal, r.localTime(anomaly.EpochTime)))
+    }
+    var buf bytes.Buffer
+    if err := r.Template.Execute(&buf, data); err != nil {
+        return "", err
+    }
+    return buf.String(), nil
+}
+
+// localTime formats an epoch in the report time zone, e.g. "2016-06-01 00:10:00 EDT"
+func (r *Renderer) localTime(epochTime int64) string {
+    return time.Unix(epochTime, 0).In(r.Location).Format("2006-01-02 15:04:05 MST")
+}
+
+func anomalyRow(anomaly, deviceId, devicePh, deviceType, signal, tm string) AnomalyRow {
+    return AnomalyRow{Anomaly: anomaly, DeviceId: deviceId, DevicePh: devicePh, DeviceType: deviceType, Signal: signal, Time: tm}
+}
+
+// DefaultHTMLTemplate lays out the Alert Details, Feeder Details and Alarm Details tables of _Alert.to_html
+const DefaultHTMLTemplate = `<center>
+<table border="1" style="border-collapse:collapse;">
+  <caption>Alert Details</caption>
+  <tbody>
+    <tr>
+      <th bgcolor="#e0e0e0">Feeder</th>
+      <td><a href="http://dpdcapps/feeders/dashboardReport?feeder={{.FeederId}}&style=dash">{{.FeederId}}</a></td>
+    </tr>
+    <tr>
+      <th bgcolor="#e0e0e0">Alert</th>
+      <td{{if .AlertColor}} bgcolor="{{.AlertColor}}"{{end}}>{{.AlertType}}</td>
+    </tr>
+    <tr>
+      <th bgcolor="#e0e0e0">Model Version</th>
+      <td>{{.ModelId}}</td>
+    </tr>
+    <tr>
+      <th bgcolor="#e0e0e0">Time</th>
+      <td>{{.Time}}</td>
+    </tr>
+  </tbody>
+</table>
+<BR>
+<BR>
+<table border="1" style="border-collapse:collapse;">
+  <caption>Feeder Details</caption>
+  <tbody>
+{{- range .Metadata}}
+    <tr>
+      <th bgcolor="#e0e0e0">{{.Label}}</th>
+      <td>{{.Value}}</td>
+    </tr>
+{{- end}}
+  </tbody>
+</table>
+<BR>
+<BR>
+<table border="1" style="border-collapse:collapse;">
+  <caption>Alarm Details</caption>
+  <thead bgcolor="#e0e0e0">
+    <tr style="text-align: center;">
+      <th>Anomaly</th>
+      <th>DeviceId</th>
+      <th>DevicePh</th>
+      <th>DeviceType</th>
+      <th>Signal</th>
+      <th>Time</th>
+    </tr>
+  </thead>
+  <tbody>
+{{- range .Anomalies}}
+    <tr>
+      <td>{{.Anomaly}}</td>
+      <td>{{.DeviceId}}</td>
+      <td>{{.DevicePh}}</td>
+      <td>{{.DeviceType}}</td>
+      <td>{{.Signal}}</td>
+      <td>{{.Time}}</td>
+    </tr>
+{{- end}}
+  </tbody>
+</table>
+<BR>
+<BR>
+Powered by AutoGrid
+</center>`
@@ -1,11 +1,10 @@
 package lib
 
 import (
-    "bufio"
     "fmt"
-    "log"
+    "io"
     "os"
-    "strings"
+    "strconv"
     "time"
 )
 
@@ -32,67 +31,114 @@ func (a *Anomaly) Populate(id string, anomaly string, deviceId string, devicePha
     a.FeederId    = feederId
     a.Signal      = signal
     a.Value       = value
-    a.Time        = tm.String()
+    a.Time        = formatAnomalyTime(tm)
     a.EpochTime   = tm.Unix()
 }
 
-func (a *Anomaly) Create(anomalyLine string) {
-    // e.g. new form 2012-01-01 00:03:07 +0000 UTC
-    // e.g. old form 2013-06-26 22:38:00+00:00
-    var tm time.Time
-    oldLongForm    := "2006-01-02 15:04:05+00:00"
-    newLongForm    := "2006-01-02 15:04:05 +0000 UTC"
-    lineComponents := strings.Split(anomalyLine, ",")
-    a.Id          = lineComponents[0]
-    a.Anomaly     = lineComponents[1]
-    a.DeviceId    = lineComponents[2]
-    a.DevicePhase = lineComponents[3]
-    a.DeviceType  = lineComponents[4]
-    a.FeederId    = lineComponents[5]
-    a.Signal      = lineComponents[6]
-    if len(lineComponents) >= 9 { // use newLongForm
-        a.Value   = lineComponents[7]
-        a.Time    = lineComponents[8]
-        tm, _     = time.Parse(newLongForm, a.Time)
-    } else { // use oldLongForm
-        a.Value   = "-"
-        a.Time    = lineComponents[7]
-        tm, _     = time.Parse(oldLongForm, a.Time)
+// Layouts of headerless anomaly files: the Go output (EpochTime is optional) and the old Python output
+var (
+    anomalyHeader    = NewCSVHeader([]string{"Id", "Anomaly", "DeviceId", "DevicePhase", "DeviceType", "FeederId",
+        "Signal", "Value", "Time", "EpochTime"})
+    oldAnomalyHeader = NewCSVHeader([]string{"Id", "Anomaly", "DeviceId", "DevicePhase", "DeviceType", "FeederId",
+        "Signal", "Time"})
+)
+
+// anomalyLayout returns the layout of a record of a headerless anomaly file
+func anomalyLayout(record []string) CSVHeader {
+    if len(record) >= 9 {
+        return anomalyHeader
+    }
+    return oldAnomalyHeader
+}
+
+// Create reads an anomaly record. The header may use the column names of the Python output (Feeder,
+// DevicePh, an unnamed index column for Id); without a Value column, Value is "-". A Time that is not a
+// time is an error.
+func (a *Anomaly) Create(record []string, header CSVHeader) error {
+    a.Id          = header.Get(record, "ID", "")
+    a.Anomaly     = header.Get(record, "ANOMALY")
+    a.DeviceId    = header.Get(record, "DEVICEID")
+    a.DevicePhase = header.Get(record, "DEVICEPHASE", "DEVICEPH")
+    a.DeviceType  = header.Get(record, "DEVICETYPE")
+    a.FeederId    = header.Get(record, "FEEDERID", "FEEDER")
+    a.Signal      = header.Get(record, "SIGNAL")
+    a.Value       = "-"
+    if header.Has("VALUE") {
+        a.Value   = header.Get(record, "VALUE")
+    }
+    a.Time        = header.Get(record, "TIME")
+    tm, err      := parseAnomalyTime(a.Time)
+    if err != nil {
+        return err
+    }
+    a.EpochTime   = tm.Unix()
+    return nil
+}
+
+// Layouts of the Time of anomaly files older than AnomalyTimeFormat: the Go output before times were
+// written in RFC 3339 and the Python output
+var oldAnomalyTimeFormats = []string{
+    "2006-01-02 15:04:05 -0700 MST", // e.g. 2012-01-01 00:03:07 +0000 UTC
+    "2006-01-02 15:04:05-07:00",     // e.g. 2013-06-26 22:38:00+00:00
+}
+
+// parseAnomalyTime parses the Time of an anomaly, in AnomalyTimeFormat or one of the older layouts
+func parseAnomalyTime(value string) (time.Time, error) {
+    tm, err := time.Parse(AnomalyTimeFormat, value)
+    for i := 0; err != nil && i < len(oldAnomalyTimeFormats); i++ {
+        tm, err = time.Parse(oldAnomalyTimeFormats[i], value)
     }
-    a.EpochTime = tm.Unix()
+    if err != nil {
+        return tm, fmt.Errorf("TIME %q is not a time like %q", value, AnomalyTimeFormat)
+    }
+    return tm, nil
 }
 
-func GetAnomalies(fileName string) map[string][]Anomaly {
+// GetAnomalies reads an anomaly file by feeder. A first record naming the columns (with an Anomaly
+// column) is the header; files without one are read in the layouts of the Go and old Python output.
+// Rows with fewer than 7 fields or a bad Time are skipped and added to bad.
+func GetAnomalies(fileName string, bad *BadRows) (map[string][]Anomaly, error) {
     var anomaliesMap map[string][]Anomaly = make(map[string][]Anomaly)
-    if file, err := os.Open(fileName); err == nil {
-        defer file.Close()
+    file, err := os.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
 
-        lineNum  := 0
-        scanner  := bufio.NewScanner(file)
-        for scanner.Scan() {
-            line := scanner.Text()
-            if lineNum > 0 { // ignore header line
-                if len(strings.Split(line, ",")) >= 7 {
-                    anomaly := new(Anomaly)
-                    anomaly.Create(line)
-                    if _, ok := anomaliesMap[anomaly.FeederId]; !ok {
-                        anomaliesMap[anomaly.FeederId] = make([]Anomaly, 0)
-                    }
-                    anomaliesMap[anomaly.FeederId] = append(anomaliesMap[anomaly.FeederId], *anomaly)
-                }
+    var header CSVHeader
+    hasHeader := false
+    reader    := newCSVReader(file)
+    for lineNum := 0; ; lineNum++ {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("%s: %v", fileName, err)
+        }
+        if lineNum == 0 {
+            if header = NewCSVHeader(record); header.Has("ANOMALY") {
+                hasHeader = true
+                continue
+            }
+        }
+        if len(record) < 7 {
+            err = fmt.Errorf("%d fields, an anomaly has at least 7", len(record))
+        } else {
+            if !hasHeader {
+                header = anomalyLayout(record)
             }
-            if lineNum % 1000000 == 0 {
-                // fmt.Printf("Reading %d ...\n", lineNum)
+            anomaly := new(Anomaly)
+            if err = anomaly.Create(record, header); err == nil {
+                anomaliesMap[anomaly.FeederId] = append(anomaliesMap[anomaly.FeederId], *anomaly)
             }
-            lineNum++
         }
-        if err = scanner.Err(); err != nil {
-            log.Fatal(err)
+        if err != nil {
+            if err = bad.Add(readRowError(fileName, reader, record, err)); err != nil {
+                return nil, err
+            }
         }
-    } else {
-        log.Fatal(err)
     }
-    return anomaliesMap
+    return anomaliesMap, nil
 }
 
 func TruncateAnomalyTimes(anomalies map[string][]Anomaly) {
@@ -105,7 +151,7 @@ func TruncateAnomalyTimes(anomalies map[string][]Anomaly) {
 }
 
 func (a *Anomaly) Format() string {
-    return fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%d",
-        a.Id, a.Anomaly, a.DeviceId, a.DevicePhase, a.DeviceType, a.FeederId, a.Signal, a.Value, a.Time, a.EpochTime)
+    return formatCSVRecord(a.Id, a.Anomaly, a.DeviceId, a.DevicePhase, a.DeviceType, a.FeederId, a.Signal, a.Value, a.Time,
+        strconv.FormatInt(a.EpochTime, 10))
 }
 
@@ -0,0 +1,106 @@
+package lib
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// AnomalySet lists the anomalies a source can extract and those it extracts by default, as
+// all_anomalies and default_anomalies do in python/anomaly.py
+type AnomalySet struct {
+    All     []string
+    Default []string
+}
+
+// AnomalySets holds the anomaly set of every source. AFS_I_FAULT_NEW and FCI_I_FAULT_NEW only exist
+// in Go and are not extracted by default. The SCADA anomalies of FDRHD, FEEDER and INTELI devices
+// prefixed with the device type (e.g. FEEDER_CURRENT_LIMIT) only exist in Go; the anomaly maps merge
+// them with the unprefixed anomaly.
+var AnomalySets = map[string]AnomalySet{
+    "edna": {
+        All: []string{
+            "AFS_ALARM_ALARM", "AFS_GROUND_ALARM", "AFS_I_FAULT_FULL", "AFS_I_FAULT_TEMP", "AFS_I_FAULT_NEW",
+            "FCI_FAULT_ALARM", "FCI_I_FAULT_FULL", "FCI_I_FAULT_TEMP", "FCI_I_FAULT_NEW",
+            "ZERO_CURRENT_V3", "ZERO_CURRENT_V4", "ZERO_POWER_V3", "ZERO_POWER_V4",
+            "ZERO_VOLTAGE_V3", "ZERO_VOLTAGE_V4", "PF_SPIKES_V3", "THD_SPIKES_V3",
+        },
+        Default: []string{
+            "AFS_ALARM_ALARM", "AFS_GROUND_ALARM", "AFS_I_FAULT_FULL", "AFS_I_FAULT_TEMP",
+            "FCI_FAULT_ALARM", "FCI_I_FAULT_FULL", "FCI_I_FAULT_TEMP",
+            "ZERO_CURRENT_V4", "ZERO_POWER_V4", "ZERO_VOLTAGE_V4", "PF_SPIKES_V3", "THD_SPIKES_V3",
+        },
+    },
+    "scada": {
+        All: []string{
+            "BKR_CLOSE", "BKR_FAIL_TO_OPR", "BKR_OPEN", "CURRENT_LIMIT",
+            "FAULT_ALARM", "FAULT_CURRENT", "FC_NO_BO",
+            "FDRHD_DE_ENERGIZED", "FDRHD_ENERGIZED", "HIGH_VOLTAGE",
+            "INTELI_PH_ALARM", "INTELI_OPS_DSW_CLOSE",
+            "INTELI_OPS_DSW_OPEN", "REGULATOR_BLOCK", "RELAY_ALARM",
+            "RELAY_TRIP", "TEMP_FAULT_CURRENT", "VOLTAGE_DROP",
+            "FDRHD_CURRENT_LIMIT", "FEEDER_CURRENT_LIMIT", "INTELI_CURRENT_LIMIT",
+            "INTELI_FAULT_CURRENT", "INTELI_TEMP_FAULT_CURRENT",
+            "FDRHD_HIGH_VOLTAGE", "FEEDER_HIGH_VOLTAGE", "INTELI_HIGH_VOLTAGE",
+            "FDRHD_VOLTAGE_DROP", "FEEDER_VOLTAGE_DROP", "INTELI_VOLTAGE_DROP",
+        },
+        Default: []string{
+            "BKR_CLOSE", "BKR_FAIL_TO_OPR", "BKR_OPEN", "CURRENT_LIMIT",
+            "FAULT_ALARM", "FAULT_CURRENT", "FC_NO_BO",
+            "FDRHD_DE_ENERGIZED", "FDRHD_ENERGIZED", "HIGH_VOLTAGE",
+            "INTELI_PH_ALARM", "INTELI_OPS_DSW_CLOSE",
+            "INTELI_OPS_DSW_OPEN", "REGULATOR_BLOCK", "RELAY_ALARM",
+            "RELAY_TRIP", "TEMP_FAULT_CURRENT", "VOLTAGE_DROP",
+            "FDRHD_CURRENT_LIMIT", "FEEDER_CURRENT_LIMIT", "INTELI_CURRENT_LIMIT",
+            "INTELI_FAULT_CURRENT", "INTELI_TEMP_FAULT_CURRENT",
+            "FDRHD_HIGH_VOLTAGE", "FEEDER_HIGH_VOLTAGE", "INTELI_HIGH_VOLTAGE",
+            "FDRHD_VOLTAGE_DROP", "FEEDER_VOLTAGE_DROP", "INTELI_VOLTAGE_DROP",
+        },
+    },
+    "ami": {
+        All:     []string{"LG_PD_10", "LG_PD_10_V2"},
+        Default: []string{"LG_PD_10_V2"},
+    },
+    "tickets": {
+        All:     []string{"RE_FUSE_ONLY", "LATERAL_OUTAGES"},
+        Default: []string{"RE_FUSE_ONLY", "LATERAL_OUTAGES"},
+    },
+}
+
+// SelectAnomalies parses a comma-separated anomaly selection of a source: anomaly names and the sets
+// "default" and "all". It returns every anomaly of the source, true if selected.
+func SelectAnomalies(source string, selection string) (map[string]bool, error) {
+    set, ok := AnomalySets[source]
+    if !ok {
+        return nil, fmt.Errorf("unknown anomaly source %q", source)
+    }
+    selected := make(map[string]bool)
+    for _, name := range set.All {
+        selected[name] = false
+    }
+    var unknown []string
+    for _, item := range SplitList(selection) {
+        switch item {
+        case "default":
+            for _, name := range set.Default {
+                selected[name] = true
+            }
+        case "all":
+            for _, name := range set.All {
+                selected[name] = true
+            }
+        default:
+            if _, ok := selected[item]; !ok {
+                unknown = append(unknown, item)
+                continue
+            }
+            selected[item] = true
+        }
+    }
+    if len(unknown) > 0 {
+        valid := append([]string{}, set.All...)
+        sort.Strings(valid)
+        return nil, fmt.Errorf("unknown %s anomalies %s (valid: default, all, %s)", source, strings.Join(unknown, ", "), strings.Join(valid, ", "))
+    }
+    return selected, nil
+}
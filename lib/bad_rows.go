@@ -0,0 +1,166 @@
+package lib
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// RowError is a row of an input file that was skipped: where it is, why, and its fields
+type RowError struct {
+    File   string
+    Line   int
+    Err    error
+    Record []string
+}
+
+func (e *RowError) Error() string {
+    return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// readRowError is the error of the record reader read last
+func readRowError(fileName string, reader *csv.Reader, record []string, err error) *RowError {
+    line, _ := reader.FieldPos(0)
+    return &RowError{File: fileName, Line: line, Err: err, Record: record}
+}
+
+// shortRowError is the error of a record with fewer fields than the header has columns
+func shortRowError(record []string, header CSVHeader) error {
+    return fmt.Errorf("%d fields, the header has %d columns", len(record), header.Width)
+}
+
+// parseTimeField parses the local time in a column of a row in the time zone of its source. A missing
+// time is the zero time, or an error if the column is required.
+func parseTimeField(column string, layout string, value string, zone *TimeZone, required bool) (time.Time, error) {
+    if value == "" {
+        if required {
+            return time.Time{}, fmt.Errorf("%s is missing", column)
+        }
+        return time.Time{}, nil
+    }
+    wall, err := time.Parse(layout, value)
+    if err != nil {
+        return wall, fmt.Errorf("%s %q is not a time like %q", column, value, layout)
+    }
+    if zone == nil {
+        return wall, nil
+    }
+    tm, err := zone.In(wall)
+    if err != nil {
+        return tm, fmt.Errorf("%s %q %v", column, value, err)
+    }
+    return tm, nil
+}
+
+// parseIntField parses the integer in a column of a row
+func parseIntField(column string, value string) (int64, error) {
+    if value == "" {
+        return 0, fmt.Errorf("%s is missing", column)
+    }
+    n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("%s %q is not an integer", column, value)
+    }
+    return n, nil
+}
+
+// parseFloatField parses the number in a column of a row
+func parseFloatField(column string, value string) (float64, error) {
+    if value == "" {
+        return 0, fmt.Errorf("%s is missing", column)
+    }
+    f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+    if err != nil {
+        return 0, fmt.Errorf("%s %q is not a number", column, value)
+    }
+    return f, nil
+}
+
+// BadRows collects the rows skipped in one input file. More than Max of them (Max < 0: no limit) fail
+// the file. A nil BadRows ignores the rows.
+type BadRows struct {
+    Max  int
+    Rows []*RowError
+}
+
+func NewBadRows(max int) *BadRows {
+    return &BadRows{Max: max}
+}
+
+// Add records a skipped row, and fails once there are more than Max
+func (b *BadRows) Add(row *RowError) error {
+    if b == nil {
+        return nil
+    }
+    b.Rows = append(b.Rows, row)
+    if b.Max >= 0 && len(b.Rows) > b.Max {
+        return fmt.Errorf("%s: more bad rows than max_bad_rows (%d), the last %v", row.File, b.Max, row)
+    }
+    return nil
+}
+
+// Count returns the number of rows skipped
+func (b *BadRows) Count() int {
+    if b == nil {
+        return 0
+    }
+    return len(b.Rows)
+}
+
+// Format summarizes the bad rows of fileName, e.g. "data.csv: 2 bad rows, the first data.csv:7: ..."
+func (b *BadRows) Format(fileName string) string {
+    if b.Count() == 0 {
+        return fileName + ": no bad rows"
+    }
+    return fmt.Sprintf("%s: %d bad rows, the first %v", fileName, b.Count(), b.Rows[0])
+}
+
+// Columns of a quarantine file: the input file and line of a bad row, why it was skipped, and the row
+// itself as a CSV line
+var quarantineColumns = []string{"FILE", "LINE", "ERROR", "ROW"}
+
+// quarantinePath returns the quarantine file of an output file, <output file without .csv>.quarantine.csv
+func quarantinePath(ofileName string) string {
+    return strings.TrimSuffix(ofileName, ".csv") + ".quarantine.csv"
+}
+
+// formatQuarantine returns the quarantine lines of rows
+func formatQuarantine(rows []*RowError) []byte {
+    var buf bytes.Buffer
+    for _, row := range rows {
+        buf.WriteString(formatCSVRecord(row.File, strconv.Itoa(row.Line), row.Err.Error(), formatCSVRecord(row.Record...)) + "\n")
+    }
+    return buf.Bytes()
+}
+
+// WriteQuarantine writes rows to the quarantine file fileName, with a header
+func WriteQuarantine(fileName string, rows []*RowError) error {
+    file, err := createOutputFile(fileName)
+    if err != nil {
+        return err
+    }
+    if _, err = file.Write(append([]byte(formatCSVRecord(quarantineColumns...) + "\n"), formatQuarantine(rows)...)); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+// openQuarantineFile creates the quarantine file of a run with its header, or with offset > 0 truncates
+// the existing file to offset to append to it
+func openQuarantineFile(fileName string, offset int64) (*os.File, int64, error) {
+    file, err := openOutputFile(fileName, offset)
+    if err != nil || offset > 0 {
+        return file, offset, err
+    }
+    header := formatCSVRecord(quarantineColumns...) + "\n"
+    if _, err = file.WriteString(header); err != nil {
+        file.Close()
+        return nil, 0, err
+    }
+    return file, int64(len(header)), nil
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestGetAnomaliesBadRows(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pam_bad_rows_")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    fileName := filepath.Join(dir, "anomalies.csv")
+    data := "0,FAULT_CURRENT,806731,A,FEEDER,806731,\"STN FEEDER 806731 IAMP LIM-HIGH 950\",950,2016-01-01 10:00:00 +0000 UTC,1451642400\n" +
+        "0,BKR_OPEN,806731,-,FEEDER,806731,-,-,yesterday,0\n" +
+        "0,BKR_OPEN\n"
+    if err = ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    bad := NewBadRows(-1)
+    anomalies, err := GetAnomalies(fileName, bad)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(anomalies["806731"]) != 1 || anomalies["806731"][0].EpochTime != 1451642400 {
+        t.Errorf("got %+v", anomalies)
+    }
+    var lines []int
+    for _, row := range bad.Rows {
+        lines = append(lines, row.Line)
+    }
+    if !reflect.DeepEqual(lines, []int{2, 3}) || !strings.Contains(bad.Rows[0].Error(), `TIME "yesterday"`) {
+        t.Errorf("bad rows: got %v", bad.Rows)
+    }
+
+    if _, err = GetAnomalies(fileName, NewBadRows(1)); err == nil || !strings.Contains(err.Error(), "max_bad_rows (1)") {
+        t.Errorf("max_bad_rows 1: got %v", err)
+    }
+
+    quarantine := filepath.Join(dir, "quarantine.csv")
+    if err = WriteQuarantine(quarantine, bad.Rows); err != nil {
+        t.Fatal(err)
+    }
+    file, err := os.Open(quarantine)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    records, err := newCSVReader(file).ReadAll()
+    if err != nil || len(records) != 3 || !reflect.DeepEqual(records[2], []string{fileName, "3",
+        "2 fields, an anomaly has at least 7", "0,BKR_OPEN"}) {
+        t.Errorf("quarantine: got %q (%v)", records, err)
+    }
+}
@@ -2,24 +2,21 @@ package lib
 
 import (
     "bufio"
+    "encoding/csv"
     "fmt"
-    "log"
+    "io"
     "os"
     "regexp"
     "sort"
     "strconv"
-    "strings"
     "time"
 )
 
-func CompareAllAnomsWithEDNAAnoms() {
-    oldLongForm := "2006-01-02 15:04:05+00:00"
-    newLongForm := "2006-01-02 15:04:05 +0000 UTC"
-    oldFileName := "/Users/sanjaynoronha/Desktop/all_anoms_feb2015.csv"
-    newFileName := "/Users/sanjaynoronha/Desktop/edna_out.txt"
-    // oldFileName := "/Users/sanjaynoronha/Desktop/all_anoms_811635.csv"
-    // newFileName := "/Users/sanjaynoronha/Desktop/edna_811635.txt"
-
+// CompareAllAnomsWithEDNAAnoms counts the anomalies of a Go anomaly file found in an old Python one. Rows
+// that cannot be read, are short or have a bad Time are skipped; more than maxBadRows of them (< 0: no
+// limit) in a file fail the compare.
+// e.g. oldFileName = "/Users/<username>/all_anoms_feb2015.csv", newFileName = "/Users/<username>/edna_out.txt"
+func CompareAllAnomsWithEDNAAnoms(oldFileName string, newFileName string, maxBadRows int) error {
     oldMap := make(map[string]map[string]map[string]map[string]string)
     newMap := make(map[string]map[string]map[string]map[string]string)
 
@@ -28,225 +25,208 @@ func CompareAllAnomsWithEDNAAnoms() {
 
     fdrRegexp, _   := regexp.Compile(`\.([0-9]{6})[\._]`)
     phaseRegexp, _ := regexp.Compile(`\.([ABC\-])_PH`)
-    loc, _         := time.LoadLocation("America/New_York")
     goodCount, badCount := 0, 0
     startTime   = time.Now()
-    if newFile, err := os.Open(newFileName); err == nil {
-        defer newFile.Close()
-        numLines := 0
-        newScanner  := bufio.NewScanner(newFile)
-        for newScanner.Scan() {
-            line := newScanner.Text()
-            lineComponents := strings.Split(line, ",")
-            if len(lineComponents) >= 2 {
-                numLines++
-                extendedId  := lineComponents[1]
-                anomalyType := lineComponents[0]
-                feederIdMatches := fdrRegexp.FindStringSubmatch(extendedId)
-                feederId    := ""
-                if len(feederIdMatches) > 0 {
-                    feederId = feederIdMatches[1]
-                }
-                phaseMatches := phaseRegexp.FindStringSubmatch(extendedId)
-                phase    := "-"
-                if len(phaseMatches) > 0 {
-                    phase = phaseMatches[1]
-                }
-                ts, _       := time.Parse(newLongForm, lineComponents[3])
-                epochTs     := strconv.FormatInt(ts.Unix(), 10)
-                if _, ok := newMap[feederId]; !ok {
-                    newMap[feederId] = map[string]map[string]map[string]string{}
-                }
-                if _, ok := newMap[feederId][anomalyType]; !ok {
-                    newMap[feederId][anomalyType] = map[string]map[string]string{}
-                }
-                if _, ok := newMap[feederId][anomalyType][phase]; !ok {
-                    newMap[feederId][anomalyType][phase] = map[string]string{}
-                }
-                if _, ok := newMap[feederId][anomalyType][phase][epochTs]; !ok {
-                    newMap[feederId][anomalyType][phase][epochTs] = line
-                }
-                if numLines % 1000000 == 0 {
-                    fmt.Printf("%d: type:%s feederId:%s line:[%s] epochTs:%s\n", numLines, anomalyType, feederId, line, epochTs)
-                }
-                
-            }
+    numLines := 0
+    newBad   := NewBadRows(maxBadRows)
+    err      := readCompareRecords(newFileName, 4, newBad, func(lineComponents []string) error {
+        ts, err := parseAnomalyTime(lineComponents[3])
+        if err != nil {
+            return err
+        }
+        line := formatCSVRecord(lineComponents...)
+        numLines++
+        extendedId  := lineComponents[1]
+        anomalyType := lineComponents[0]
+        feederIdMatches := fdrRegexp.FindStringSubmatch(extendedId)
+        feederId    := ""
+        if len(feederIdMatches) > 0 {
+            feederId = feederIdMatches[1]
+        }
+        phaseMatches := phaseRegexp.FindStringSubmatch(extendedId)
+        phase    := "-"
+        if len(phaseMatches) > 0 {
+            phase = phaseMatches[1]
+        }
+        epochTs     := strconv.FormatInt(ts.Unix(), 10)
+        if _, ok := newMap[feederId]; !ok {
+            newMap[feederId] = map[string]map[string]map[string]string{}
+        }
+        if _, ok := newMap[feederId][anomalyType]; !ok {
+            newMap[feederId][anomalyType] = map[string]map[string]string{}
+        }
+        if _, ok := newMap[feederId][anomalyType][phase]; !ok {
+            newMap[feederId][anomalyType][phase] = map[string]string{}
+        }
+        if _, ok := newMap[feederId][anomalyType][phase][epochTs]; !ok {
+            newMap[feederId][anomalyType][phase][epochTs] = line
         }
+        if numLines % 1000000 == 0 {
+            fmt.Printf("%d: type:%s feederId:%s line:[%s] epochTs:%s\n", numLines, anomalyType, feederId, line, epochTs)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    elapsed := time.Since(startTime)
+    fmt.Printf("{numLines: %d, badRows: %d, elapsed: %s}\n", numLines, newBad.Count(), elapsed)
 
-        elapsed := time.Since(startTime)
-        fmt.Printf("{numLines: %d, elapsed: %s}\n", numLines, elapsed)
-        
-        // check for errors
-        if err = newScanner.Err(); err != nil {
-            log.Fatal(err)
+    numLines  = 0
+    oldBad   := NewBadRows(maxBadRows)
+    err       = readCompareRecords(oldFileName, 8, oldBad, func(lineComponents []string) error {
+        ts, err := parseAnomalyTime(lineComponents[7]) // both files are in UTC
+        if err != nil {
+            return err
+        }
+        line := formatCSVRecord(lineComponents...)
+        numLines++
+        anomalyType := lineComponents[1]
+        phase       := lineComponents[3]
+        feederId    := lineComponents[5]
+        epochTs     := strconv.FormatInt(ts.Unix(), 10)
+        if _, ok := oldMap[feederId]; !ok {
+            oldMap[feederId] = map[string]map[string]map[string]string{}
+        }
+        if _, ok := oldMap[feederId][anomalyType]; !ok {
+            oldMap[feederId][anomalyType] = map[string]map[string]string{}
+        }
+        if _, ok := oldMap[feederId][anomalyType][phase]; !ok {
+            oldMap[feederId][anomalyType][phase] = map[string]string{}
+        }
+        if _, ok := oldMap[feederId][anomalyType][phase][epochTs]; !ok {
+            oldMap[feederId][anomalyType][phase][epochTs] = line
+        }
+        if _, ok := newMap[feederId][anomalyType][phase][epochTs]; ok {
+            goodCount++
+        } else {
+            badCount++
         }
+        if numLines % 1000000 == 0 {
+            fmt.Printf("%d: type:%s [%s] epochTs:%s\n", numLines, anomalyType, line, epochTs)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
 
-    } else {
-        log.Fatal(err)
+    elapsed  = time.Since(startTime)
+    fmt.Printf("{numLines: %d, goodCount: %d, badCount: %d, badRows: %d, elapsed: %s}\n", numLines, goodCount, badCount,
+        oldBad.Count(), elapsed)
+    if newBad.Count() > 0 {
+        fmt.Println(newBad.Format(newFileName))
+    }
+    if oldBad.Count() > 0 {
+        fmt.Println(oldBad.Format(oldFileName))
     }
 
-    
-    if oldFile, err := os.Open(oldFileName); err == nil {
-        defer oldFile.Close()
-        numLines := 0
-        oldScanner  := bufio.NewScanner(oldFile)
-        for oldScanner.Scan() {
-            line := oldScanner.Text()
-            lineComponents := strings.Split(line, ",")
-            if len(lineComponents) >= 7 {
-                numLines++
-                extendedId  := lineComponents[6]
-                _ = extendedId
-                anomalyType := lineComponents[1]
-                phase       := lineComponents[3]
-                feederId    := lineComponents[5]
-                ts, _       := time.Parse(oldLongForm, lineComponents[7])
-                _, offset   := ts.In(loc).Zone()
-                ts           = ts.Add(time.Duration(offset) * time.Second) // timestamps are ET, convert to UTC
-                epochTs     := strconv.FormatInt(ts.Unix(), 10)
-                if _, ok := oldMap[feederId]; !ok {
-                    oldMap[feederId] = map[string]map[string]map[string]string{}
-                }
-                if _, ok := oldMap[feederId][anomalyType]; !ok {
-                    oldMap[feederId][anomalyType] = map[string]map[string]string{}
-                }
-                if _, ok := oldMap[feederId][anomalyType][phase]; !ok {
-                    oldMap[feederId][anomalyType][phase] = map[string]string{}
-                }
-                if _, ok := oldMap[feederId][anomalyType][phase][epochTs]; !ok {
-                    oldMap[feederId][anomalyType][phase][epochTs] = line
-                }
-                if _, ok := newMap[feederId][anomalyType][phase][epochTs]; ok {
-                    goodCount++
+    var feederIds []string
+    for feederId := range newMap {
+        feederIds = append(feederIds, feederId)
+    }
+    sort.Strings(feederIds)
+    for _, feederId := range feederIds {
+        for fault := range newMap[feederId] {
+            for phase := range newMap[feederId][fault] {
+                oldCount := len(oldMap[feederId][fault][phase])
+                newCount := len(newMap[feederId][fault][phase])
+                var absDiff int = 0
+                if oldCount > newCount {
+                    absDiff = oldCount - newCount
                 } else {
-                    badCount++
-                    if badCount % 1 == 0 {
-                        // fmt.Printf("BAD: %d type:%s phase:%s [%s] epochTs:%s\n", numLines, anomalyType, phase, line, epochTs)
-                    }
+                    absDiff = newCount - oldCount
                 }
-                if numLines % 1000000 == 0 {
-                    fmt.Printf("%d: type:%s [%s] epochTs:%s\n", numLines, anomalyType, line, epochTs)
-                }
-            }
-        }
-
-        elapsed := time.Since(startTime)
-        fmt.Printf("{numLines: %d, goodCount: %d, badCount: %d, elapsed: %s}\n", numLines, goodCount, badCount, elapsed)
-
-        var feederIds []string
-        for feederId := range newMap {
-            feederIds = append(feederIds, feederId)
-        }
-        sort.Strings(feederIds)
-        for _, feederId := range feederIds {
-            for fault := range newMap[feederId] {
-                for phase := range newMap[feederId][fault] {
-                    oldCount := len(oldMap[feederId][fault][phase])
-                    newCount := len(newMap[feederId][fault][phase])
-                    var absDiff int = 0
-                    if oldCount > newCount {
-                        absDiff = oldCount - newCount
-                    } else {
-                        absDiff = newCount - oldCount
-                    }
-                    if absDiff >= 100 {
-                        fmt.Printf("[%s][%s][%s] = {old:%d, new:%d]\n", feederId, fault, phase, oldCount, newCount)
-                    }
+                if absDiff >= 100 {
+                    fmt.Printf("[%s][%s][%s] = {old:%d, new:%d]\n", feederId, fault, phase, oldCount, newCount)
                 }
             }
         }
-        
-        // check for errors
-        if err = oldScanner.Err(); err != nil {
-            log.Fatal(err)
-        }
-
-    } else {
-        log.Fatal(err)
     }
-
-
+    return nil
 }
 
+// SortMergeAnomalyFile merges a Go anomaly file and an old Python one into <newFilePath>_merged<newExtension>,
+// sorted by time. Rows that cannot be read, are short or have a bad Time are skipped; more than maxBadRows
+// of them (< 0: no limit) in a file fail the merge.
 // e.g. fileName = "/Users/<username>/edna_monthly", extension = ".csv"
-func SortMergeAnomalyFile(newFilePath string, newExtension string, oldFilePath string, oldExtension string) {
+func SortMergeAnomalyFile(newFilePath string, newExtension string, oldFilePath string, oldExtension string, maxBadRows int) error {
     var anomObjects []Anomaly
     numLines := 0
-    newLongForm := "2006-01-02 15:04:05 +0000 UTC"
     newFileName := newFilePath + newExtension
-    oldLongForm := "2006-01-02 15:04:05+00:00"
     oldFileName := oldFilePath + oldExtension
 
     // Read, parse new file
-    if newFile, err := os.Open(newFileName); err == nil {
-        // make sure it gets closed
-        defer newFile.Close()
-        scanner  := bufio.NewScanner(newFile)
-        for scanner.Scan() {
-            line := scanner.Text()
-            lineComponents := strings.Split(line, ",")
-            if len(lineComponents) >= 5 {
-                anom            := new(Anomaly)
+    newBad := NewBadRows(maxBadRows)
+    err    := readCompareRecords(newFileName, 9, newBad, func(lineComponents []string) error {
+        anom            := new(Anomaly)
 
-                // 0,FCI_FAULT_ALARM,673113B,B,FCI,806731,IVES.806731.FCI.673113B.FAULT.B_PH,1,2013-12-05 15:41:26 +0000 UTC
-                anom.Id          = lineComponents[0]
-                anom.Anomaly     = lineComponents[1]
-                anom.DeviceId    = lineComponents[2]
-                anom.DevicePhase = lineComponents[3]
-                anom.DeviceType  = lineComponents[4]
-                anom.FeederId    = lineComponents[5]
-                anom.Signal      = lineComponents[6]
-                anom.Value       = lineComponents[7]
-                anom.Time        = lineComponents[8]
+        // 0,FCI_FAULT_ALARM,673113B,B,FCI,806731,IVES.806731.FCI.673113B.FAULT.B_PH,1,2013-12-05T15:41:26Z
+        anom.Id          = lineComponents[0]
+        anom.Anomaly     = lineComponents[1]
+        anom.DeviceId    = lineComponents[2]
+        anom.DevicePhase = lineComponents[3]
+        anom.DeviceType  = lineComponents[4]
+        anom.FeederId    = lineComponents[5]
+        anom.Signal      = lineComponents[6]
+        anom.Value       = lineComponents[7]
+        anom.Time        = lineComponents[8]
 
-                evntTs, _       := time.Parse(newLongForm, anom.Time)
-                anom.EpochTime   = evntTs.Unix()
-                anomObjects      = append(anomObjects, *anom)
-                if numLines % 1000000 == 0 {
-                    fmt.Printf("%d\tnew %s epoch: %d\n", numLines, anom.Time, anom.EpochTime)
-                }
-                numLines++
-            }
+        evntTs, err     := parseAnomalyTime(anom.Time)
+        if err != nil {
+            return err
         }
-    } else {
-        log.Fatal(err)
+        anom.EpochTime   = evntTs.Unix()
+        anom.Time        = formatAnomalyTime(evntTs)
+        anomObjects      = append(anomObjects, *anom)
+        if numLines % 1000000 == 0 {
+            fmt.Printf("%d\tnew %s epoch: %d\n", numLines, anom.Time, anom.EpochTime)
+        }
+        numLines++
+        return nil
+    })
+    if err != nil {
+        return err
     }
     fmt.Printf("NumLines: %d\n", numLines)
 
     // Read, parse old file
-    if oldFile, err := os.Open(oldFileName); err == nil {
-        // make sure it gets closed
-        defer oldFile.Close()
-        scanner  := bufio.NewScanner(oldFile)
-        for scanner.Scan() {
-            line := scanner.Text()
-            lineComponents := strings.Split(line, ",")
-            if len(lineComponents) >= 5 {
-                anom            := new(Anomaly)
+    oldBad := NewBadRows(maxBadRows)
+    err     = readCompareRecords(oldFileName, 8, oldBad, func(lineComponents []string) error {
+        anom            := new(Anomaly)
 
-                anom.Id          = lineComponents[0]
-                anom.Anomaly     = lineComponents[1]
-                anom.DeviceId    = lineComponents[2]
-                anom.DevicePhase = lineComponents[3]
-                anom.DeviceType  = lineComponents[4]
-                anom.FeederId    = lineComponents[5]
-                anom.Signal      = lineComponents[6]
-                anom.Value       = "0"
-                anom.Time        = lineComponents[7]
+        anom.Id          = lineComponents[0]
+        anom.Anomaly     = lineComponents[1]
+        anom.DeviceId    = lineComponents[2]
+        anom.DevicePhase = lineComponents[3]
+        anom.DeviceType  = lineComponents[4]
+        anom.FeederId    = lineComponents[5]
+        anom.Signal      = lineComponents[6]
+        anom.Value       = "0"
+        anom.Time        = lineComponents[7]
 
-                evntTs, _       := time.Parse(oldLongForm, anom.Time)
-                anom.EpochTime   = evntTs.Unix()
-                anom.Time        = fmt.Sprintf("%s", evntTs)
-                anomObjects      = append(anomObjects, *anom)
+        evntTs, err     := parseAnomalyTime(anom.Time)
+        if err != nil {
+            return err
+        }
+        anom.EpochTime   = evntTs.Unix()
+        anom.Time        = formatAnomalyTime(evntTs)
+        anomObjects      = append(anomObjects, *anom)
 
-                if numLines % 100000 == 0 {
-                    fmt.Printf("%d\told %s epoch: %d\n", numLines, anom.Time, anom.EpochTime)
-                }
-                numLines++
-            }
+        if numLines % 100000 == 0 {
+            fmt.Printf("%d\told %s epoch: %d\n", numLines, anom.Time, anom.EpochTime)
         }
-    } else {
-        log.Fatal(err)
+        numLines++
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    if newBad.Count() > 0 {
+        fmt.Println(newBad.Format(newFileName))
+    }
+    if oldBad.Count() > 0 {
+        fmt.Println(oldBad.Format(oldFileName))
     }
 
     sort.Slice(anomObjects, func(i, j int) bool {
@@ -255,19 +235,52 @@ func SortMergeAnomalyFile(newFilePath string, newExtension string, oldFilePath s
     fmt.Printf("Sorting done!\n")
 
     // Write out sorted, merged files
-    oFileName := newFilePath + "_merged" + newExtension
-    var writer *bufio.Writer
-    if ofile, err := os.Create(oFileName); err == nil {
-        defer ofile.Close()
-        writer = bufio.NewWriter(ofile)
-    } else {
-        log.Fatal(err)
+    oFileName  := newFilePath + "_merged" + newExtension
+    ofile, err := os.Create(oFileName)
+    if err != nil {
+        return err
     }
-    line := ""
+    defer ofile.Close()
+    writer := bufio.NewWriter(ofile)
     for _, anom := range anomObjects {
-        line = anom.Id + "," + anom.Anomaly + "," + anom.DeviceId + "," + anom.DevicePhase + "," + anom.DeviceType + "," + anom.FeederId + "," +
-            anom.Signal + "," + anom.Value + "," + anom.Time
+        line := formatCSVRecord(anom.Id, anom.Anomaly, anom.DeviceId, anom.DevicePhase, anom.DeviceType, anom.FeederId,
+            anom.Signal, anom.Value, anom.Time)
         writer.WriteString(fmt.Sprintf("%s\n", line))
     }
-    writer.Flush()
+    if err = writer.Flush(); err != nil {
+        return err
+    }
+    return ofile.Close()
+}
+
+// readCompareRecords calls fn with the records of an anomaly file. Records that cannot be read, have fewer
+// than minFields fields or that fn returns an error for are skipped and added to bad.
+func readCompareRecords(fileName string, minFields int, bad *BadRows, fn func(record []string) error) error {
+    file, err := os.Open(fileName)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    reader := newCSVReader(file)
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            return nil
+        }
+        var row *RowError
+        if parseErr, ok := err.(*csv.ParseError); ok {
+            row = &RowError{File: fileName, Line: parseErr.StartLine, Err: parseErr.Err, Record: record}
+        } else if err != nil {
+            return fmt.Errorf("%s: %v", fileName, err)
+        } else if len(record) < minFields {
+            row = readRowError(fileName, reader, record, fmt.Errorf("%d fields, an anomaly has at least %d", len(record), minFields))
+        } else if err = fn(record); err != nil {
+            row = readRowError(fileName, reader, record, err)
+        }
+        if row != nil {
+            if err = bad.Add(row); err != nil {
+                return err
+            }
+        }
+    }
 }
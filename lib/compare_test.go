@@ -0,0 +1,43 @@
+package lib
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestSortMergeBadRows(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pam_merge_")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    newData := "0,FCI_FAULT_ALARM,DEV9,A,FCI,123456,SUB.123456.FCI.DEV9.FAULT.A_PH,1,2016-01-01T05:13:41Z\n" +
+        "0,FCI_FAULT_ALARM,DEV9,A,FCI,123456,SUB.123456.FCI.DEV9.FAULT.A_PH,1,not a time\n" +
+        "0,FCI_FAULT_ALARM,DEV9\n"
+    oldData := "3,BKR_OPEN,BKR,-,SCADA,123456,SUB.123456.BKR,2016-01-01 05:04:53+00:00\n"
+    if err = ioutil.WriteFile(filepath.Join(dir, "new.csv"), []byte(newData), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err = ioutil.WriteFile(filepath.Join(dir, "old.csv"), []byte(oldData), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    if err = SortMergeAnomalyFile(filepath.Join(dir, "new"), ".csv", filepath.Join(dir, "old"), ".csv", 2); err != nil {
+        t.Fatal(err)
+    }
+    merged, err := ioutil.ReadFile(filepath.Join(dir, "new_merged.csv"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := "3,BKR_OPEN,BKR,-,SCADA,123456,SUB.123456.BKR,0,2016-01-01T05:04:53Z\n" +
+        "0,FCI_FAULT_ALARM,DEV9,A,FCI,123456,SUB.123456.FCI.DEV9.FAULT.A_PH,1,2016-01-01T05:13:41Z\n"
+    if string(merged) != want {
+        t.Errorf("got %q, want %q", merged, want)
+    }
+
+    if err = SortMergeAnomalyFile(filepath.Join(dir, "new"), ".csv", filepath.Join(dir, "old"), ".csv", 1); err == nil {
+        t.Errorf("2 bad rows, max_bad_rows 1: got no error")
+    }
+}
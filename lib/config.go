@@ -0,0 +1,354 @@
+package lib
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Run configuration shared by every processor. Loaded from a YAML or JSON file (see
+// config.example.yaml); any value can be overridden from the command line with Set.
+type Config struct {
+    Input             InputConfig    `yaml:"input"               json:"input"`
+    OutputDir         string         `yaml:"output_dir"          json:"output_dir"`
+    FeederMetadata    string         `yaml:"feeder_metadata"     json:"feeder_metadata"`
+    DataDir           string         `yaml:"data_dir"            json:"data_dir"`
+    DatasetVersion    string         `yaml:"dataset_version"     json:"dataset_version"`
+    AnomalyMapVersion string         `yaml:"anomaly_map_version" json:"anomaly_map_version"`
+    EdnaRulesVersion  string         `yaml:"edna_rules_version"  json:"edna_rules_version"`
+    MaxBadRows        string         `yaml:"max_bad_rows"        json:"max_bad_rows"` // per input file, empty for no limit
+    Anomalies         AnomalyConfig  `yaml:"anomalies"           json:"anomalies"`
+    Scada             ScadaConfig    `yaml:"scada"               json:"scada"`
+    Tickets           TicketConfig   `yaml:"tickets"             json:"tickets"`
+    TimeZones         TimeZoneConfig `yaml:"time_zones"          json:"time_zones"`
+}
+
+type InputConfig struct {
+    BulkRoot      string `yaml:"bulk_root"      json:"bulk_root"`      // contains edna/response, ami and scada
+    MonthlyRoot   string `yaml:"monthly_root"   json:"monthly_root"`   // contains one directory per month
+    S3Bucket      string `yaml:"s3_bucket"      json:"s3_bucket"`
+    S3Region      string `yaml:"s3_region"      json:"s3_region"`
+    S3Profile     string `yaml:"s3_profile"     json:"s3_profile"`
+    TicketsDir    string `yaml:"tickets_dir"    json:"tickets_dir"`
+    AnomaliesFile string `yaml:"anomalies_file" json:"anomalies_file"` // anomalies read by the signature stage
+}
+
+// AnomalyConfig selects the anomalies extracted from each source: comma-separated anomaly names and
+// the sets "default" and "all" (see SelectAnomalies)
+type AnomalyConfig struct {
+    Edna    string `yaml:"edna"    json:"edna"`
+    Scada   string `yaml:"scada"   json:"scada"`
+    Ami     string `yaml:"ami"     json:"ami"`
+    Tickets string `yaml:"tickets" json:"tickets"`
+}
+
+// ScadaConfig holds the FC_NO_BO interval: a fault current with no BKR_OPEN on its feeder from
+// FcNoBoBefore before it to FcNoBoAfter after it is an FC_NO_BO (durations, e.g. "1m")
+type ScadaConfig struct {
+    FcNoBoBefore string `yaml:"fc_no_bo_before" json:"fc_no_bo_before"`
+    FcNoBoAfter  string `yaml:"fc_no_bo_after"  json:"fc_no_bo_after"`
+}
+
+// FcNoBoInterval parses the FC_NO_BO interval
+func (s *ScadaConfig) FcNoBoInterval() (time.Duration, time.Duration, error) {
+    before, err := time.ParseDuration(s.FcNoBoBefore)
+    if err != nil {
+        return 0, 0, fmt.Errorf("scada.fc_no_bo_before: %v", err)
+    }
+    after, err := time.ParseDuration(s.FcNoBoAfter)
+    if err != nil {
+        return 0, 0, fmt.Errorf("scada.fc_no_bo_after: %v", err)
+    }
+    if before < 0 || after < 0 {
+        return 0, 0, fmt.Errorf("scada.fc_no_bo_before and scada.fc_no_bo_after must not be negative")
+    }
+    return before, after, nil
+}
+
+// BadRowLimit parses max_bad_rows: the rows of an input file that may be skipped as unreadable before
+// the file fails, -1 for no limit
+func (c *Config) BadRowLimit() (int, error) {
+    if c.MaxBadRows == "" {
+        return -1, nil
+    }
+    max, err := strconv.Atoi(c.MaxBadRows)
+    if err != nil || max < 0 {
+        return 0, fmt.Errorf("max_bad_rows: %q is not a number of rows", c.MaxBadRows)
+    }
+    return max, nil
+}
+
+// Source returns the selection of a source
+func (a *AnomalyConfig) Source(source string) string {
+    switch source {
+    case "edna":
+        return a.Edna
+    case "scada":
+        return a.Scada
+    case "ami":
+        return a.Ami
+    case "tickets":
+        return a.Tickets
+    }
+    return ""
+}
+
+// DefaultConfig returns the values used when neither a config file nor a flag sets them.
+// Paths are relative to the working directory; input roots have no default.
+func DefaultConfig() *Config {
+    return &Config{
+        Input: InputConfig{
+            S3Bucket:      "pam-monthly-anomalies",
+            S3Region:      "us-west-2",
+            S3Profile:     "fpl_user",
+            TicketsDir:    "data/tickets",
+            AnomaliesFile: "data/all_anoms.csv",
+        },
+        OutputDir:         "output",
+        FeederMetadata:    "data/feeder_metadata.csv",
+        DataDir:           "data",
+        DatasetVersion:    "1_0",
+        AnomalyMapVersion: "1_0",
+        EdnaRulesVersion:  "1_0",
+        Anomalies:         AnomalyConfig{Edna: "default", Scada: "default", Ami: "default", Tickets: "default"},
+        Scada:             ScadaConfig{FcNoBoBefore: "1m", FcNoBoAfter: "2m"},
+        Tickets:           TicketConfig{CauseCodes: "188,189", TypeCodes: "FDR,OCR", Dedup: TicketDedupEarliest},
+        TimeZones:         TimeZoneConfig{Edna: "America/New_York", Scada: "America/New_York", Ami: "America/New_York",
+            Tickets: "America/New_York", Ambiguous: AmbiguousEarlier, Nonexistent: NonexistentForward},
+    }
+}
+
+// LoadConfig reads fileName (JSON if it ends in .json, YAML otherwise) over DefaultConfig. Unknown keys
+// are an error.
+func LoadConfig(fileName string) (*Config, error) {
+    cfg := DefaultConfig()
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return nil, err
+    }
+    if strings.EqualFold(filepath.Ext(fileName), ".json") {
+        decoder := json.NewDecoder(bytes.NewReader(data))
+        decoder.DisallowUnknownFields()
+        err = decoder.Decode(cfg)
+    } else {
+        decoder := yaml.NewDecoder(bytes.NewReader(data))
+        decoder.KnownFields(true)
+        if err = decoder.Decode(cfg); err == io.EOF { // an empty file keeps the defaults
+            err = nil
+        }
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+    return cfg, nil
+}
+
+// ConfigKeys lists the keys accepted by Set, in the order they appear in a config file
+var ConfigKeys = []string{
+    "input.bulk_root", "input.monthly_root", "input.s3_bucket", "input.s3_region", "input.s3_profile",
+    "input.tickets_dir", "input.anomalies_file",
+    "output_dir", "feeder_metadata", "data_dir", "dataset_version", "anomaly_map_version",
+    "edna_rules_version", "max_bad_rows", "anomalies.edna", "anomalies.scada", "anomalies.ami",
+    "anomalies.tickets",
+    "scada.fc_no_bo_before", "scada.fc_no_bo_after",
+    "tickets.cause_codes", "tickets.type_codes", "tickets.green_ticket", "tickets.min_cmi", "tickets.from_date",
+    "tickets.to_date", "tickets.dedup",
+    "time_zones.edna", "time_zones.scada", "time_zones.ami", "time_zones.tickets", "time_zones.ambiguous",
+    "time_zones.nonexistent",
+}
+
+// Set overrides a single value, e.g. Set("input.bulk_root", "/data/bulk")
+func (c *Config) Set(key string, value string) error {
+    switch key {
+    case "input.bulk_root":
+        c.Input.BulkRoot = value
+    case "input.monthly_root":
+        c.Input.MonthlyRoot = value
+    case "input.s3_bucket":
+        c.Input.S3Bucket = value
+    case "input.s3_region":
+        c.Input.S3Region = value
+    case "input.s3_profile":
+        c.Input.S3Profile = value
+    case "input.tickets_dir":
+        c.Input.TicketsDir = value
+    case "input.anomalies_file":
+        c.Input.AnomaliesFile = value
+    case "output_dir":
+        c.OutputDir = value
+    case "feeder_metadata":
+        c.FeederMetadata = value
+    case "data_dir":
+        c.DataDir = value
+    case "dataset_version":
+        c.DatasetVersion = value
+    case "anomaly_map_version":
+        c.AnomalyMapVersion = value
+    case "edna_rules_version":
+        c.EdnaRulesVersion = value
+    case "max_bad_rows":
+        c.MaxBadRows = value
+    case "anomalies.edna":
+        c.Anomalies.Edna = value
+    case "anomalies.scada":
+        c.Anomalies.Scada = value
+    case "anomalies.ami":
+        c.Anomalies.Ami = value
+    case "anomalies.tickets":
+        c.Anomalies.Tickets = value
+    case "scada.fc_no_bo_before":
+        c.Scada.FcNoBoBefore = value
+    case "scada.fc_no_bo_after":
+        c.Scada.FcNoBoAfter = value
+    case "tickets.cause_codes":
+        c.Tickets.CauseCodes = value
+    case "tickets.type_codes":
+        c.Tickets.TypeCodes = value
+    case "tickets.green_ticket":
+        c.Tickets.GreenTicket = value
+    case "tickets.min_cmi":
+        c.Tickets.MinCMI = value
+    case "tickets.from_date":
+        c.Tickets.FromDate = value
+    case "tickets.to_date":
+        c.Tickets.ToDate = value
+    case "tickets.dedup":
+        c.Tickets.Dedup = value
+    case "time_zones.edna":
+        c.TimeZones.Edna = value
+    case "time_zones.scada":
+        c.TimeZones.Scada = value
+    case "time_zones.ami":
+        c.TimeZones.Ami = value
+    case "time_zones.tickets":
+        c.TimeZones.Tickets = value
+    case "time_zones.ambiguous":
+        c.TimeZones.Ambiguous = value
+    case "time_zones.nonexistent":
+        c.TimeZones.Nonexistent = value
+    default:
+        return fmt.Errorf("unknown config key %q", key)
+    }
+    return nil
+}
+
+// Require fails with the config key of the first empty value among keys
+func (c *Config) Require(keys ...string) error {
+    values := map[string]string{
+        "input.bulk_root":      c.Input.BulkRoot,
+        "input.monthly_root":   c.Input.MonthlyRoot,
+        "input.s3_bucket":      c.Input.S3Bucket,
+        "input.s3_region":      c.Input.S3Region,
+        "input.tickets_dir":    c.Input.TicketsDir,
+        "input.anomalies_file": c.Input.AnomaliesFile,
+        "output_dir":           c.OutputDir,
+        "feeder_metadata":      c.FeederMetadata,
+        "data_dir":             c.DataDir,
+        "dataset_version":      c.DatasetVersion,
+        "anomaly_map_version":  c.AnomalyMapVersion,
+        "edna_rules_version":   c.EdnaRulesVersion,
+    }
+    for _, key := range keys {
+        if values[key] == "" {
+            return fmt.Errorf("%s is not set: add it to the run config or pass it as a flag", key)
+        }
+    }
+    return nil
+}
+
+// OutputPath returns fileName inside the output directory
+func (c *Config) OutputPath(fileName string) string {
+    return filepath.Join(c.OutputDir, fileName)
+}
+
+// DataPath returns the path of a versioned data file, e.g. DataPath("dataset", "1_0", ".yaml")
+// gives <data_dir>/pam_1_0_dataset.yaml
+func (c *Config) DataPath(kind string, version string, extension string) string {
+    return modelFilePath(c.DataDir, kind, version, extension)
+}
+
+func modelFilePath(dataDir string, kind string, version string, extension string) string {
+    return filepath.Join(dataDir, "pam_"+version+"_"+kind+extension)
+}
+
+// anomalyRequiredKeys lists the config keys the anomaly processor of source needs for the chosen input
+func anomalyRequiredKeys(source string, isBulk bool, isLocal bool) []string {
+    switch source {
+    case "edna":
+        return append(inputKeys(isBulk, isLocal), "data_dir", "edna_rules_version")
+    case "ami":
+        return append(inputKeys(isBulk, isLocal), "feeder_metadata")
+    case "scada":
+        return []string{"input.bulk_root", "output_dir"}
+    case "tickets":
+        return []string{"input.tickets_dir", "output_dir"}
+    }
+    return nil
+}
+
+// signatureRequiredKeys lists the config keys ProcessSignature needs
+var signatureRequiredKeys = []string{"feeder_metadata", "data_dir", "dataset_version", "anomaly_map_version",
+    "input.tickets_dir", "input.anomalies_file", "output_dir"}
+
+// CheckAnomalyConfig returns the first problem of the run config the anomaly processor of source would
+// fail on: a missing key, a bad time zone or a bad FC_NO_BO interval. It lets a command line report them
+// before the run starts.
+func (c *Config) CheckAnomalyConfig(source string, isBulk bool, isLocal bool) error {
+    if err := c.Require(anomalyRequiredKeys(source, isBulk, isLocal)...); err != nil {
+        return err
+    }
+    if _, err := c.TimeZones.Zone(source); err != nil {
+        return err
+    }
+    if source == "scada" {
+        if _, _, err := c.Scada.FcNoBoInterval(); err != nil {
+            return err
+        }
+    }
+    _, err := c.BadRowLimit()
+    return err
+}
+
+// CheckSignatureConfig returns the first problem of the run config ProcessSignature would fail on: a
+// missing key, a bad time zone or ticket selection, or a bad max_bad_rows
+func (c *Config) CheckSignatureConfig() error {
+    if err := c.Require(signatureRequiredKeys...); err != nil {
+        return err
+    }
+    zone, err := c.TimeZones.Zone("tickets")
+    if err != nil {
+        return err
+    }
+    if _, err = c.Tickets.Filter(zone.Location); err != nil {
+        return err
+    }
+    _, err = c.BadRowLimit()
+    return err
+}
+
+// inputKeys lists the config keys a processor needs for the chosen input
+func inputKeys(isBulk bool, isLocal bool) []string {
+    if isBulk {
+        return []string{"input.bulk_root", "output_dir"}
+    } else if isLocal {
+        return []string{"input.monthly_root", "output_dir"}
+    }
+    return []string{"input.s3_bucket", "input.s3_region", "output_dir"}
+}
+
+// createOutputFile creates fileName, creating its directory first if needed
+func createOutputFile(fileName string) (*os.File, error) {
+    if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+        return nil, err
+    }
+    return os.Create(fileName)
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+    if _, err := LoadConfig("../config.example.yaml"); err != nil {
+        t.Errorf("config.example.yaml: %v", err)
+    }
+    dir, err := ioutil.TempDir("", "pam_config_")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    files := map[string]string{
+        "empty.yaml": "",
+        "bad.yaml":   "output_dir: out\ninput:\n  bulk_rot: /data\n",
+        "bad.json":   `{"output_dir": "out", "input": {"bulk_rot": "/data"}}`,
+    }
+    for name, data := range files {
+        fileName := filepath.Join(dir, name)
+        if err = ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+            t.Fatal(err)
+        }
+        _, err = LoadConfig(fileName)
+        if name == "empty.yaml" {
+            if err != nil {
+                t.Errorf("%s: %v", name, err)
+            }
+        } else if err == nil || !strings.Contains(err.Error(), "bulk_rot") {
+            t.Errorf("%s: got %v, want an error naming bulk_rot", name, err)
+        }
+    }
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+    "bufio"
+    "encoding/csv"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// newCSVReader reads RFC 4180 records from r: quoted fields with embedded commas, quotes and line breaks,
+// CRLF line ends and a leading UTF-8 byte order mark. Records may have any number of fields, and a stray
+// quote in an unquoted field is kept as it is.
+func newCSVReader(r io.Reader) *csv.Reader {
+    buffered := bufio.NewReader(r)
+    if bom, err := buffered.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+        buffered.Discard(3)
+    }
+    reader := csv.NewReader(buffered)
+    reader.FieldsPerRecord = -1
+    reader.LazyQuotes      = true
+    return reader
+}
+
+// CSVHeader maps the columns of a CSV header to their indexes. Names are matched upper-cased and without
+// spaces, so "Extended Id" is EXTENDEDID; the first of repeated names wins.
+type CSVHeader struct {
+    index map[string]int
+    Names []string // the column names as they are in the header
+    Width int      // number of columns
+}
+
+// NewCSVHeader reads a header record
+func NewCSVHeader(record []string) CSVHeader {
+    h := CSVHeader{index: make(map[string]int), Names: record, Width: len(record)}
+    for i, name := range record {
+        name = csvColumnName(name)
+        if _, ok := h.index[name]; !ok {
+            h.index[name] = i
+        }
+    }
+    return h
+}
+
+func csvColumnName(name string) string {
+    return strings.ToUpper(strings.Replace(strings.TrimSpace(name), " ", "", -1))
+}
+
+// Has reports whether the header has one of the columns names (aliases of one column)
+func (h CSVHeader) Has(names ...string) bool {
+    for _, name := range names {
+        if _, ok := h.index[name]; ok {
+            return true
+        }
+    }
+    return false
+}
+
+// Require fails with the missing columns among columns; each entry lists the aliases of one column,
+// e.g. {"FEEDERID", "FEEDER"}
+func (h CSVHeader) Require(columns ...[]string) error {
+    var missing []string
+    for _, names := range columns {
+        if !h.Has(names...) {
+            missing = append(missing, strings.Join(names, "/"))
+        }
+    }
+    if len(missing) > 0 {
+        return fmt.Errorf("missing columns %s", strings.Join(missing, ", "))
+    }
+    return nil
+}
+
+// Get returns the field of the first of names (aliases of one column, upper-cased without spaces) that the
+// header has, "" if it has none or the record is too short
+func (h CSVHeader) Get(record []string, names ...string) string {
+    for _, name := range names {
+        if i, ok := h.index[name]; ok {
+            if i < len(record) {
+                return record[i]
+            }
+            return ""
+        }
+    }
+    return ""
+}
+
+// readCSVHeader reads the header of fileName, which must have the columns named
+func readCSVHeader(fileName string, reader *csv.Reader, columnNames ...string) (CSVHeader, error) {
+    record, err := reader.Read()
+    if err == io.EOF {
+        return CSVHeader{}, fmt.Errorf("%s: no header", fileName)
+    } else if err != nil {
+        return CSVHeader{}, fmt.Errorf("%s: %v", fileName, err)
+    }
+    header  := NewCSVHeader(record)
+    columns := make([][]string, len(columnNames))
+    for i, name := range columnNames {
+        columns[i] = []string{csvColumnName(name)}
+    }
+    if err = header.Require(columns...); err != nil {
+        return header, fmt.Errorf("%s: %v", fileName, err)
+    }
+    return header, nil
+}
+
+// formatCSVRecord joins fields into a CSV line, quoting those with commas, quotes or line breaks
+func formatCSVRecord(fields ...string) string {
+    var line strings.Builder
+    for i, field := range fields {
+        if i > 0 {
+            line.WriteByte(',')
+        }
+        if strings.ContainsAny(field, ",\"\r\n") {
+            field = "\"" + strings.Replace(field, "\"", "\"\"", -1) + "\""
+        }
+        line.WriteString(field)
+    }
+    return line.String()
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestCSVReader(t *testing.T) {
+    data   := "\xef\xbb\xbfExtended Id,Time,Value String\r\n\"A.1\",\"1/1/2016 12:00:05 AM\",\"x,\"\"y\"\"\"\r\n\"A.2\",t,\"two\nlines\"\r\nA.3\r\n"
+    reader := newCSVReader(strings.NewReader(data))
+    header, err := readCSVHeader("test.csv", reader, "Extended Id", "TIME")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got [][]string
+    for i := 0; i < 3; i++ {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal(err)
+        }
+        got = append(got, []string{header.Get(record, "EXTENDEDID"), header.Get(record, "VALUESTRING")})
+    }
+    want := [][]string{{"A.1", "x,\"y\""}, {"A.2", "two\nlines"}, {"A.3", ""}}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got %q, want %q", got, want)
+    }
+
+    if _, err = readCSVHeader("test.csv", newCSVReader(strings.NewReader("Time,Value\n")), "Extended Id"); err == nil ||
+        !strings.Contains(err.Error(), "missing columns EXTENDEDID") {
+        t.Errorf("missing column: got %v", err)
+    }
+}
+
+func TestFormatCSVRecord(t *testing.T) {
+    fields := []string{"0", "FAULT_CURRENT", "STN FEEDER 806731 IAMP LIM-HIGH 950 a,b", "say \"hi\"", ""}
+    line   := formatCSVRecord(fields...)
+    if line != "0,FAULT_CURRENT,\"STN FEEDER 806731 IAMP LIM-HIGH 950 a,b\",\"say \"\"hi\"\"\"," {
+        t.Errorf("got %s", line)
+    }
+    record, err := newCSVReader(strings.NewReader(line + "\n")).Read()
+    if err != nil || !reflect.DeepEqual(record, fields) {
+        t.Errorf("read back %q (%v), want %q", record, err, fields)
+    }
+}
@@ -1,54 +1,117 @@
 package lib
 
 import (
-    "bufio"
-    "log"
-    "os"
+    "fmt"
+    "io/ioutil"
     "strings"
-    "strconv"
+
+    "gopkg.in/yaml.v3"
 )
 
+// One column of a model's dataset config (data/pam_<version>_dataset.yaml)
 type DatasetObject struct {
-    Name    string
-    Lookup  string
-    Type    string
-    MinLag  int64
-    MaxLag  int64
-    KeepAll bool
+    Name    string `yaml:"name"`
+    Lookup  string `yaml:"lookup"`
+    Type    string `yaml:"type"`
+    MinLag  int64  `yaml:"min_lag"`
+    MaxLag  int64  `yaml:"max_lag"`
+    KeepAll bool   `yaml:"keep_all"`
+    Line    int    `yaml:"-"` // line of the column in the config file
+}
+
+// DatasetColumnTypes are the valid values of `type`
+var DatasetColumnTypes = []string{"trigger", "background", "cluster", "sequence", "flag", "special", "duration", "constant"}
+
+// IsAnomalyLookup reports whether the column counts anomalies named by Lookup in a [MinLag, MaxLag] window
+func (d *DatasetObject) IsAnomalyLookup() bool {
+    switch d.Type {
+    case "trigger", "background", "cluster", "sequence", "flag":
+        return true
+    }
+    return false
+}
+
+// GetDataset loads the dataset config of a model version ("1_0", "2_1", "3_0a") from dataDir
+func GetDataset(dataDir string, version string) ([]DatasetObject, error) {
+    return LoadDataset(modelFilePath(dataDir, "dataset", version, ".yaml"))
 }
 
-func (d *DatasetObject) Create(datasetLine string) {
-    lineComponents  := strings.Split(datasetLine, ",")
-    d.Name           = lineComponents[0]
-    d.Lookup         = lineComponents[1]
-    d.Type           = lineComponents[2]
-    d.MinLag, _      = strconv.ParseInt(lineComponents[3], 10, 64)
-    d.MaxLag, _      = strconv.ParseInt(lineComponents[4], 10, 64)
-    d.KeepAll, _     = strconv.ParseBool(lineComponents[5])
+// LoadDataset reads a dataset config, keeping the column order of the file. Columns without
+// a lookup use their name (constant and duration columns read the feeder metadata column of
+// that name).
+func LoadDataset(fileName string) ([]DatasetObject, error) {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return nil, err
+    }
+    var doc yaml.Node
+    if err = yaml.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+    if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.SequenceNode {
+        return nil, fmt.Errorf("%s: expected a list of columns", fileName)
+    }
+
+    var dataset []DatasetObject
+    names := make(map[string]int)
+    for _, node := range doc.Content[0].Content {
+        datasetObj, err := decodeDatasetObject(node)
+        if typeErr, ok := err.(*yaml.TypeError); ok { // already carries "line N: ..."
+            return nil, fmt.Errorf("%s: %s", fileName, strings.Join(typeErr.Errors, "; "))
+        } else if err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", fileName, node.Line, err)
+        }
+        if line, ok := names[datasetObj.Name]; ok {
+            return nil, fmt.Errorf("%s:%d: duplicate column %s (first defined on line %d)", fileName, node.Line, datasetObj.Name, line)
+        }
+        names[datasetObj.Name] = node.Line
+        dataset = append(dataset, datasetObj)
+    }
+    if len(dataset) == 0 {
+        return nil, fmt.Errorf("%s: no columns defined", fileName)
+    }
+    return dataset, nil
 }
 
-func GetDatasetMap(fileName string) map[string]DatasetObject {
-    var datasetMap map[string]DatasetObject = make(map[string]DatasetObject)
-    if file, err := os.Open(fileName); err == nil {
-        defer file.Close()
-        scanner   := bufio.NewScanner(file)
-        lineCount := 0
-        for scanner.Scan() {
-            line := scanner.Text()
-            if lineCount > 0 {
-                if len(strings.Split(line, ",")) >= 5 {
-                    datasetObj := new(DatasetObject)
-                    datasetObj.Create(line)
-                    datasetMap[datasetObj.Name] = *datasetObj
-                }
-            }
-            lineCount++
+func decodeDatasetObject(node *yaml.Node) (DatasetObject, error) {
+    var datasetObj DatasetObject
+    if node.Kind != yaml.MappingNode {
+        return datasetObj, fmt.Errorf("expected a column mapping")
+    }
+    for i := 0; i < len(node.Content); i += 2 {
+        switch key := node.Content[i].Value; key {
+        case "name", "lookup", "type", "min_lag", "max_lag", "keep_all":
+        default:
+            return datasetObj, fmt.Errorf("unknown key %q", key)
+        }
+    }
+    if err := node.Decode(&datasetObj); err != nil {
+        return datasetObj, err
+    }
+    datasetObj.Line = node.Line
+
+    if datasetObj.Name == "" {
+        return datasetObj, fmt.Errorf("column has no name")
+    }
+    valid := false
+    for _, columnType := range DatasetColumnTypes {
+        valid = valid || datasetObj.Type == columnType
+    }
+    if !valid {
+        return datasetObj, fmt.Errorf("column %s has unknown type %q (valid types: %s)",
+            datasetObj.Name, datasetObj.Type, strings.Join(DatasetColumnTypes, ", "))
+    }
+    if datasetObj.IsAnomalyLookup() {
+        if datasetObj.Lookup == "" {
+            return datasetObj, fmt.Errorf("%s column %s has no lookup", datasetObj.Type, datasetObj.Name)
         }
-        if err = scanner.Err(); err != nil {
-            log.Fatal(err)
+        if datasetObj.MinLag < 0 || datasetObj.MaxLag <= datasetObj.MinLag {
+            return datasetObj, fmt.Errorf("column %s has an empty window [min_lag=%d, max_lag=%d]",
+                datasetObj.Name, datasetObj.MinLag, datasetObj.MaxLag)
         }
-    } else {
-        log.Fatal(err)
     }
-    return datasetMap
+    if datasetObj.Lookup == "" {
+        datasetObj.Lookup = datasetObj.Name
+    }
+    return datasetObj, nil
 }
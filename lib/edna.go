@@ -1,21 +1,50 @@
 package lib
 
 import (
-    "strings"
+    "strconv"
     "time"
 )
 
+// Columns of eDNA files, as named in their header: Extended Id,Time,Value,ValueString,Status
+var ednaColumns = []string{"Extended Id", "Time", "Value", "ValueString", "Status"}
+
+// Columns of the sorted runs of an unsorted eDNA file: those of the file and the line each row is from
+var ednaRunColumns = append(append([]string{}, ednaColumns...), "Line")
+
 type IndexedEDNA struct {
-    // Extended Id,Time,Value,ValueString,Status
-    EdnaLine     string
+    ExtendedId   string
+    TimeString   string
+    Value        string
+    ValueString  string
+    Status       string
     Time         time.Time
     EpochTime    int64
+    Line         int // of the input file
+}
+
+// Create reads an eDNA row, with its Time a local time in zone. A Time that is missing or not a time is
+// an error.
+func (i *IndexedEDNA) Create(record []string, header CSVHeader, zone *TimeZone) error {
+    longForm      := "1/2/2006 3:04:05 PM"
+    i.ExtendedId   = header.Get(record, "EXTENDEDID")
+    i.TimeString   = header.Get(record, "TIME")
+    i.Value        = header.Get(record, "VALUE")
+    i.ValueString  = header.Get(record, "VALUESTRING")
+    i.Status       = header.Get(record, "STATUS")
+    var err error
+    if i.Time, err = parseTimeField("TIME", longForm, i.TimeString, zone, true); err != nil {
+        return err
+    }
+    i.EpochTime    = i.Time.Unix()
+    return nil
+}
+
+// Record returns the fields of the line in the order of ednaColumns
+func (i *IndexedEDNA) Record() []string {
+    return []string{i.ExtendedId, i.TimeString, i.Value, i.ValueString, i.Status}
 }
 
-func (i *IndexedEDNA) Create(ednaLine string) {
-    longForm    := "1/2/2006 3:04:05 PM"
-    i.EdnaLine   = ednaLine
-    lineComponents := strings.Split(ednaLine, ",")
-    i.Time, _    = time.Parse(longForm, strings.Replace(lineComponents[1], "\"", "", -1))
-    i.EpochTime  = i.Time.Unix()
+// runRecord returns the fields of the line in the order of ednaRunColumns
+func (i *IndexedEDNA) runRecord() []string {
+    return append(i.Record(), strconv.Itoa(i.Line))
 }
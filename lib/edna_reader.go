@@ -0,0 +1,272 @@
+package lib
+
+import (
+    "bufio"
+    "container/heap"
+    "encoding/csv"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "sort"
+    "strconv"
+)
+
+// Time order of eDNA input files: checked before processing (auto), trusted (sorted), or not assumed
+// (unsorted). Unsorted files are sorted with an external merge sort.
+const (
+    EdnaOrderAuto     = "auto"
+    EdnaOrderSorted   = "sorted"
+    EdnaOrderUnsorted = "unsorted"
+)
+
+// Lines of an unsorted file sorted in memory at a time; longer files spill sorted runs to disk
+var ednaSortRunLines = 1000000
+
+// readEDNA calls fn with the lines of an eDNA file in time order, lines with the same time in file order,
+// and stops at the first error fn returns. Times are read in zone. Rows with fewer fields than the header
+// has columns, or a bad Time, are skipped and added to bad. Sorted files are streamed; unsorted files keep
+// at most ednaSortRunLines lines in memory.
+func readEDNA(fileName string, order string, zone *TimeZone, bad *BadRows, fn func(line IndexedEDNA) error) error {
+    switch order {
+    case EdnaOrderAuto:
+        sorted, err := ednaSorted(fileName, zone)
+        if err != nil {
+            return err
+        }
+        if !sorted {
+            return sortEDNA(fileName, zone, bad, fn)
+        }
+        return scanEDNA(fileName, true, zone, bad, fn)
+    case EdnaOrderSorted:
+        return scanEDNA(fileName, true, zone, bad, fn)
+    case EdnaOrderUnsorted:
+        return sortEDNA(fileName, zone, bad, fn)
+    }
+    return fmt.Errorf("unknown eDNA order %q (valid orders: auto, sorted, unsorted)", order)
+}
+
+// ValidEdnaOrder reports whether order is auto, sorted or unsorted
+func ValidEdnaOrder(order string) bool {
+    return order == EdnaOrderAuto || order == EdnaOrderSorted || order == EdnaOrderUnsorted
+}
+
+// scanEDNA calls fn with the lines of an eDNA file in file order, adding the rows it skips to bad. With
+// checkOrder, a line earlier than the one before it is an error.
+func scanEDNA(fileName string, checkOrder bool, zone *TimeZone, bad *BadRows, fn func(line IndexedEDNA) error) error {
+    file, err := os.Open(fileName)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    reader      := newCSVReader(file)
+    header, err := readCSVHeader(fileName, reader, ednaColumns...)
+    if err != nil {
+        return err
+    }
+    var lastEpochTime int64
+    numLines := 0
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            return nil
+        } else if err != nil {
+            return fmt.Errorf("%s: %v", fileName, err)
+        }
+        ednaLine := new(IndexedEDNA)
+        if len(record) < header.Width {
+            err = shortRowError(record, header)
+        } else {
+            err = ednaLine.Create(record, header, zone)
+        }
+        if err != nil {
+            if err = bad.Add(readRowError(fileName, reader, record, err)); err != nil {
+                return err
+            }
+            continue
+        }
+        ednaLine.Line, _ = reader.FieldPos(0)
+        if checkOrder && numLines > 0 && ednaLine.EpochTime < lastEpochTime {
+            return fmt.Errorf("%s:%d: line is earlier than the line before it; the file is not sorted by time", fileName, ednaLine.Line)
+        }
+        lastEpochTime = ednaLine.EpochTime
+        numLines++
+        if err = fn(*ednaLine); err != nil {
+            return err
+        }
+    }
+}
+
+// ednaSorted reports whether the lines of an eDNA file are in time order. Bad rows are left to the read
+// that follows.
+func ednaSorted(fileName string, zone *TimeZone) (bool, error) {
+    sorted := true
+    var lastEpochTime int64
+    first  := true
+    err    := scanEDNA(fileName, false, zone, nil, func(line IndexedEDNA) error {
+        if !first && line.EpochTime < lastEpochTime {
+            sorted = false
+        }
+        lastEpochTime, first = line.EpochTime, false
+        return nil
+    })
+    return sorted, err
+}
+
+// sortEDNA calls fn with the lines of an eDNA file sorted by time. Runs of ednaSortRunLines lines are
+// sorted in memory; when there is more than one, each is written to a temporary file and the runs are
+// merged.
+func sortEDNA(fileName string, zone *TimeZone, bad *BadRows, fn func(line IndexedEDNA) error) error {
+    var run []IndexedEDNA
+    var runFiles []string
+    defer func() {
+        for _, runFile := range runFiles {
+            os.Remove(runFile)
+        }
+    }()
+    spill := func() error {
+        runFile, err := writeEDNARun(run)
+        if err != nil {
+            return err
+        }
+        runFiles = append(runFiles, runFile)
+        run      = run[:0]
+        return nil
+    }
+
+    err := scanEDNA(fileName, false, zone, bad, func(line IndexedEDNA) error {
+        run = append(run, line)
+        if len(run) >= ednaSortRunLines {
+            return spill()
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    if len(runFiles) == 0 {
+        sortEDNALines(run)
+        for _, line := range run {
+            if err = fn(line); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    if len(run) > 0 {
+        if err = spill(); err != nil {
+            return err
+        }
+    }
+    run = nil
+    return mergeEDNARuns(runFiles, zone, fn)
+}
+
+func sortEDNALines(lines []IndexedEDNA) {
+    sort.SliceStable(lines, func(i, j int) bool {
+        return lines[i].EpochTime < lines[j].EpochTime
+    })
+}
+
+// writeEDNARun sorts lines and writes them to a temporary CSV file with the header of ednaRunColumns
+func writeEDNARun(lines []IndexedEDNA) (string, error) {
+    sortEDNALines(lines)
+    file, err := ioutil.TempFile("", "pam_edna_run_")
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+    writer := bufio.NewWriter(file)
+    writer.WriteString(formatCSVRecord(ednaRunColumns...) + "\n")
+    for _, line := range lines {
+        writer.WriteString(formatCSVRecord(line.runRecord()...) + "\n")
+    }
+    if err = writer.Flush(); err != nil {
+        os.Remove(file.Name())
+        return "", err
+    }
+    return file.Name(), file.Close()
+}
+
+// One sorted run of a merge: its next line and the reader of the rest
+type ednaRun struct {
+    index  int
+    line   IndexedEDNA
+    reader *csv.Reader
+    header CSVHeader
+    zone   *TimeZone
+    err    error
+}
+
+// ednaRunHeap orders runs by the time of their next line, then by run (the order of the file)
+type ednaRunHeap []*ednaRun
+
+func (h ednaRunHeap) Len() int      { return len(h) }
+func (h ednaRunHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h ednaRunHeap) Less(i, j int) bool {
+    if h[i].line.EpochTime == h[j].line.EpochTime {
+        return h[i].index < h[j].index
+    }
+    return h[i].line.EpochTime < h[j].line.EpochTime
+}
+func (h *ednaRunHeap) Push(x interface{}) { *h = append(*h, x.(*ednaRun)) }
+func (h *ednaRunHeap) Pop() interface{} {
+    old := *h
+    run := old[len(old) - 1]
+    *h   = old[:len(old) - 1]
+    return run
+}
+
+// next reads the next line of the run, false at its end or on an error (in r.err)
+func (r *ednaRun) next() bool {
+    record, err := r.reader.Read()
+    if err != nil {
+        if err != io.EOF {
+            r.err = err
+        }
+        return false
+    }
+    if r.err = r.line.Create(record, r.header, r.zone); r.err != nil {
+        return false
+    }
+    r.line.Line, _ = strconv.Atoi(r.header.Get(record, "LINE"))
+    return true
+}
+
+// mergeEDNARuns calls fn with the lines of the sorted run files in time order, reading times in zone
+func mergeEDNARuns(runFiles []string, zone *TimeZone, fn func(line IndexedEDNA) error) error {
+    runs := &ednaRunHeap{}
+    for i, runFile := range runFiles {
+        file, err := os.Open(runFile)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        run := &ednaRun{index: i, reader: newCSVReader(file), zone: zone}
+        if run.header, err = readCSVHeader(runFile, run.reader, ednaRunColumns...); err != nil {
+            return err
+        }
+        if run.next() {
+            heap.Push(runs, run)
+        } else if run.err != nil {
+            return run.err
+        }
+    }
+    for runs.Len() > 0 {
+        run := (*runs)[0]
+        if err := fn(run.line); err != nil {
+            return err
+        }
+        if run.next() {
+            heap.Fix(runs, 0)
+        } else {
+            if run.err != nil {
+                return run.err
+            }
+            heap.Pop(runs)
+        }
+    }
+    return nil
+}
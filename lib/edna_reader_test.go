@@ -0,0 +1,69 @@
+package lib
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestReadEDNAMerge(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pam_edna_reader_")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    runDir := filepath.Join(dir, "runs")
+    if err = os.Mkdir(runDir, 0755); err != nil {
+        t.Fatal(err)
+    }
+    defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+    os.Setenv("TMPDIR", runDir)
+    defer func(runLines int) { ednaSortRunLines = runLines }(ednaSortRunLines)
+    ednaSortRunLines = 3
+
+    // minutes past midnight of each line; the value of a line is its position
+    minutes := []int{5, 3, 3, 9, 1, 3, 7, 2, 8, 0}
+    var data bytes.Buffer
+    data.WriteString("Extended Id,Time,Value,ValueString,Status\n")
+    start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+    for k, minute := range minutes {
+        tm := start.Add(time.Duration(minute) * time.Minute)
+        fmt.Fprintf(&data, "SUB.FDR.123456_D4.MW,%s,%d,%d,OK\n", tm.Format("1/2/2006 3:04:05 PM"), k, k)
+    }
+    fileName := filepath.Join(dir, "123456.csv")
+    if err = ioutil.WriteFile(fileName, data.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    zone := &TimeZone{Location: time.UTC, Ambiguous: AmbiguousEarlier, Nonexistent: NonexistentForward}
+    read := func(order string) ([]string, []int, error) {
+        var values []string
+        var lines  []int
+        err := readEDNA(fileName, order, zone, nil, func(line IndexedEDNA) error {
+            values = append(values, line.Value)
+            lines  = append(lines, line.Line)
+            return nil
+        })
+        return values, lines, err
+    }
+    // by time, lines with the same time in file order
+    wantValues := []string{"9", "4", "7", "1", "2", "5", "0", "6", "8", "3"}
+    wantLines  := []int{11, 6, 9, 3, 4, 7, 2, 8, 10, 5}
+    for _, order := range []string{EdnaOrderAuto, EdnaOrderUnsorted} {
+        values, lines, err := read(order)
+        if err != nil || !reflect.DeepEqual(values, wantValues) || !reflect.DeepEqual(lines, wantLines) {
+            t.Errorf("%s: got %v, lines %v (%v), want %v, lines %v", order, values, lines, err, wantValues, wantLines)
+        }
+    }
+    if _, _, err = read(EdnaOrderSorted); err == nil {
+        t.Errorf("sorted: got no error for an unsorted file")
+    }
+    if runFiles, _ := ioutil.ReadDir(runDir); len(runFiles) > 0 {
+        t.Errorf("%d run files left in the temporary directory", len(runFiles))
+    }
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+
+    "gopkg.in/yaml.v3"
+)
+
+// EdnaRules holds the detection thresholds of processEDNAFile. It is loaded from
+// data/pam_<version>_edna_rules.yaml, so thresholds can be tuned without a rebuild.
+type EdnaRules struct {
+    Version      string           `yaml:"version"`
+    FaultCurrent FaultCurrentRule `yaml:"fault_current"`
+    ZeroCurrent  ZeroRule         `yaml:"zero_current"`
+    ZeroPower    ZeroRule         `yaml:"zero_power"`
+    ZeroVoltage  ZeroRule         `yaml:"zero_voltage"`
+    PfSpikes     SpikeRule        `yaml:"pf_spikes"`
+    ThdSpikes    ThdRule          `yaml:"thd_spikes"`
+    DedupSeconds int64            `yaml:"dedup_seconds"` // repeats of an anomaly on a signal within this are dropped
+}
+
+// FaultCurrentRule sets the amps of the AFS and FCI I_FAULT anomalies: *_I_FAULT_TEMP from Temp up to
+// Full, *_I_FAULT_FULL from Full, *_I_FAULT_NEW from New
+type FaultCurrentRule struct {
+    Temp int `yaml:"temp"`
+    Full int `yaml:"full"`
+    New  int `yaml:"new"`
+}
+
+// ZeroRule detects a value dropping into (Low, High): the _V3 anomaly when at least Quantile of the
+// 24 hour window (of at least MinElements values) is at or above Threshold, the _V4 anomaly when the
+// previous value is at or above Previous
+type ZeroRule struct {
+    Low         float64 `yaml:"low"`
+    High        float64 `yaml:"high"`
+    Quantile    float64 `yaml:"quantile"`
+    Threshold   float64 `yaml:"threshold"`
+    MinElements int     `yaml:"min_elements"`
+    Previous    float64 `yaml:"previous"`
+}
+
+// SpikeRule detects an absolute value below High when at least Quantile of the 24 hour window (of at
+// least MinElements absolute values) is at or above Threshold
+type SpikeRule struct {
+    High        float64 `yaml:"high"`
+    Quantile    float64 `yaml:"quantile"`
+    Threshold   float64 `yaml:"threshold"`
+    MinElements int     `yaml:"min_elements"`
+}
+
+// ThdRule detects a value more than Sigmas standard deviations above the mean of the 24 hour window
+type ThdRule struct {
+    Sigmas float64 `yaml:"sigmas"`
+}
+
+// GetEdnaRules loads the eDNA rules of a version ("1_0") from dataDir
+func GetEdnaRules(dataDir string, version string) (*EdnaRules, error) {
+    fileName   := modelFilePath(dataDir, "edna_rules", version, ".yaml")
+    rules, err := LoadEdnaRules(fileName)
+    if err != nil {
+        return nil, err
+    }
+    if rules.Version != version {
+        return nil, fmt.Errorf("%s: version %q does not match its file name", fileName, rules.Version)
+    }
+    return rules, nil
+}
+
+// LoadEdnaRules reads a rules YAML file. Every rule must be given; unknown keys are rejected.
+func LoadEdnaRules(fileName string) (*EdnaRules, error) {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return nil, err
+    }
+    rules   := new(EdnaRules)
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    decoder.KnownFields(true)
+    if err = decoder.Decode(rules); err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+    if err = rules.Validate(); err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+    return rules, nil
+}
+
+// Validate checks that the thresholds are consistent, which also catches missing rules
+func (r *EdnaRules) Validate() error {
+    if r.Version == "" {
+        return fmt.Errorf("no version")
+    }
+    if r.FaultCurrent.Temp <= 0 || r.FaultCurrent.Full < r.FaultCurrent.Temp || r.FaultCurrent.New <= 0 {
+        return fmt.Errorf("fault_current: need 0 < temp <= full and new > 0")
+    }
+    for name, rule := range map[string]ZeroRule{"zero_current": r.ZeroCurrent, "zero_power": r.ZeroPower, "zero_voltage": r.ZeroVoltage} {
+        if rule.Low >= rule.High {
+            return fmt.Errorf("%s: low %g is not below high %g", name, rule.Low, rule.High)
+        }
+        if rule.Quantile <= 0 || rule.Quantile > 1 || rule.MinElements < 0 {
+            return fmt.Errorf("%s: need 0 < quantile <= 1 and min_elements >= 0", name)
+        }
+    }
+    if r.PfSpikes.High <= 0 || r.PfSpikes.Quantile <= 0 || r.PfSpikes.Quantile > 1 || r.PfSpikes.MinElements < 0 {
+        return fmt.Errorf("pf_spikes: need high > 0, 0 < quantile <= 1 and min_elements >= 0")
+    }
+    if r.ThdSpikes.Sigmas <= 0 {
+        return fmt.Errorf("thd_spikes: sigmas must be positive")
+    }
+    if r.DedupSeconds < 0 {
+        return fmt.Errorf("dedup_seconds must not be negative")
+    }
+    return nil
+}
+
+// writeEdnaRules writes the rules of a run next to its output, as <output file>.rules.yaml. When
+// resuming, the rules must be those of the interrupted run.
+func writeEdnaRules(ofileName string, rules *EdnaRules, resume bool) error {
+    data, err := yaml.Marshal(rules)
+    if err != nil {
+        return err
+    }
+    fileName := ofileName + ".rules.yaml"
+    if resume {
+        if old, err := ioutil.ReadFile(fileName); err == nil && !bytes.Equal(old, data) {
+            return fmt.Errorf("%s: the rules changed since the interrupted run; rerun without -resume", fileName)
+        }
+    }
+    file, err := createOutputFile(fileName)
+    if err != nil {
+        return err
+    }
+    if _, err = file.Write(data); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
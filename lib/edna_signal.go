@@ -0,0 +1,151 @@
+package lib
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// Device types of eDNA signals, as get_device_type in python/anomaly.py
+const (
+    DeviceAFS     = "AFS"
+    DeviceFCI     = "FCI"
+    DevicePhaser  = "PHASER"
+    DeviceBreaker = "BKR"
+    DeviceUnknown = "UNKNOWN"
+)
+
+// Quantities of phaser signals. Points of AFS and FCI signals (ALARM, GROUND, FAULT, I_FAULT) are kept
+// as they are.
+const (
+    QuantityCurrent = "I"
+    QuantityVoltage = "V"
+    QuantityPower   = "MW"
+    QuantityPF      = "PF"
+    QuantityTHD     = "THD" // THD of the current
+)
+
+var (
+    signalFeederRegexp = regexp.MustCompile(`^[0-9]{6}$`)
+    signalPhaseRegexp  = regexp.MustCompile(`^([ABC\-])_PH`)
+)
+
+// EdnaSignalID is a parsed eDNA extended ID, e.g. IVES.806731.FCI.673113B.FAULT.B_PH (substation, feeder,
+// device type, device ID, quantity and phase) or a phaser signal such as IVES.FDR.806731_1.I.A_PH
+type EdnaSignalID struct {
+    ID         string
+    Substation string
+    Feeder     string // 6-digit feeder ID, "" if there is none
+    DeviceType string // DeviceAFS, DeviceFCI, DevicePhaser, DeviceBreaker or DeviceUnknown
+    DeviceID   string // "-" if a phaser signal has none
+    Quantity   string // a Quantity* constant for phasers, the point (e.g. I_FAULT) for AFS and FCI
+    Phase      string // A, B, C or -, "" if the signal has no phase
+}
+
+// ParseEdnaSignalID parses an extended ID. An ID with fewer than three components, or an AFS or FCI
+// signal without device ID and point, is an error.
+func ParseEdnaSignalID(extendedId string) (*EdnaSignalID, error) {
+    parts := strings.Split(extendedId, ".")
+    if len(parts) < 3 || parts[0] == "" {
+        return nil, fmt.Errorf("malformed eDNA signal %q: want SUBSTATION.FEEDER.DEVICE...", extendedId)
+    }
+    s := &EdnaSignalID{ID: extendedId, Substation: parts[0], DeviceType: DeviceUnknown, DeviceID: "-"}
+
+    // the feeder is a 6-digit component, or the 6 digits before the _ of a phaser component
+    for _, part := range parts[1:] {
+        if feeder := strings.SplitN(part, "_", 2)[0]; signalFeederRegexp.MatchString(feeder) {
+            s.Feeder = feeder
+            break
+        }
+    }
+    for _, part := range parts[1:] {
+        if matches := signalPhaseRegexp.FindStringSubmatch(part); len(matches) > 0 {
+            s.Phase = matches[1]
+            break
+        }
+    }
+
+    isFeeder, isBreaker := false, false
+    for i, part := range parts[1:] {
+        switch {
+        case part == DeviceAFS || part == DeviceFCI:
+            // SUBSTATION.FEEDER.AFS|FCI.DEVICE.POINT[.PHASE]
+            if i + 3 >= len(parts) || parts[i + 2] == "" || parts[i + 3] == "" {
+                return nil, fmt.Errorf("malformed eDNA signal %q: %s without device ID and point", extendedId, part)
+            }
+            s.DeviceType, s.DeviceID, s.Quantity = part, parts[i + 2], parts[i + 3]
+            return s, nil
+        case part == "FDR":
+            isFeeder = true
+        case strings.HasSuffix(part, DeviceBreaker):
+            isBreaker = true
+        }
+    }
+    switch {
+    case isBreaker:
+        s.DeviceType = DeviceBreaker
+    case isFeeder:
+        s.DeviceType = DevicePhaser
+        // SUBSTATION.FDR.FEEDER_DEVICE.QUANTITY[.PHASE]
+        if device := strings.Split(parts[2], "_"); len(device) >= 2 && device[1] != "" {
+            s.DeviceID = device[1]
+        }
+    }
+    s.Quantity = signalQuantity(parts[1:])
+    return s, nil
+}
+
+// signalQuantity returns the first phaser quantity among the components of an extended ID, "" if none
+func signalQuantity(parts []string) string {
+    for _, part := range parts {
+        switch {
+        case part == QuantityCurrent || part == QuantityVoltage || part == QuantityPF:
+            return part
+        case strings.HasPrefix(part, QuantityPower):
+            return QuantityPower
+        case strings.HasPrefix(part, QuantityTHD + "_") && strings.Contains(strings.ToLower(part), "current"):
+            return QuantityTHD
+        }
+    }
+    return ""
+}
+
+// PhaseOrDash returns the phase of the signal, "-" if it has none, as written to anomaly files
+func (s *EdnaSignalID) PhaseOrDash() string {
+    if s.Phase == "" {
+        return "-"
+    }
+    return s.Phase
+}
+
+// EdnaSignalRule selects the eDNA signals a detection applies to. Empty fields match any signal.
+type EdnaSignalRule struct {
+    DeviceType string
+    Quantity   string
+    Phased     bool // the signal must have a phase
+}
+
+// Signals of the eDNA detections of processEDNAFile
+var (
+    afsAlarmSignals    = EdnaSignalRule{DeviceType: DeviceAFS, Quantity: "ALARM"}
+    afsGroundSignals   = EdnaSignalRule{DeviceType: DeviceAFS, Quantity: "GROUND"}
+    afsFaultSignals    = EdnaSignalRule{DeviceType: DeviceAFS, Quantity: "I_FAULT"}
+    fciAlarmSignals    = EdnaSignalRule{DeviceType: DeviceFCI, Quantity: "FAULT"}
+    fciFaultSignals    = EdnaSignalRule{DeviceType: DeviceFCI, Quantity: "I_FAULT"}
+    zeroCurrentSignals = EdnaSignalRule{DeviceType: DevicePhaser, Quantity: QuantityCurrent, Phased: true}
+    zeroPowerSignals   = EdnaSignalRule{DeviceType: DevicePhaser, Quantity: QuantityPower}
+    zeroVoltageSignals = EdnaSignalRule{DeviceType: DevicePhaser, Quantity: QuantityVoltage, Phased: true}
+    pfSpikesSignals    = EdnaSignalRule{DeviceType: DevicePhaser, Quantity: QuantityPF, Phased: true}
+    thdSpikesSignals   = EdnaSignalRule{Quantity: QuantityTHD}
+)
+
+// Signals whose values the detections read as numbers
+var ednaNumericSignals = []EdnaSignalRule{afsFaultSignals, fciFaultSignals, zeroCurrentSignals, zeroPowerSignals,
+    zeroVoltageSignals, pfSpikesSignals, thdSpikesSignals}
+
+// Matches reports whether the rule selects signal s
+func (r EdnaSignalRule) Matches(s *EdnaSignalID) bool {
+    return (r.DeviceType == "" || s.DeviceType == r.DeviceType) &&
+        (r.Quantity == "" || s.Quantity == r.Quantity) &&
+        (!r.Phased || s.Phase != "")
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+    "bufio"
+    "io"
+    "os"
+    "sort"
+    "time"
+)
+
+// Anomalies FcNoBo looks for: fault currents and the breaker opens that clear them
+var (
+    fcNoBoFaults = map[string]bool{"FAULT_CURRENT": true, "TEMP_FAULT_CURRENT": true,
+        "INTELI_FAULT_CURRENT": true, "INTELI_TEMP_FAULT_CURRENT": true}
+    fcNoBoOpens  = map[string]bool{"BKR_OPEN": true}
+)
+
+// FcNoBoMissing returns the anomalies a selection with FC_NO_BO must also select, because appendFcNoBo
+// finds FC_NO_BO in the breaker opens and fault currents written to the output
+func FcNoBoMissing(processAnomaly map[string]bool) []string {
+    var missing []string
+    if !processAnomaly["FC_NO_BO"] {
+        return missing
+    }
+    if !processAnomaly["BKR_OPEN"] {
+        missing = append(missing, "BKR_OPEN")
+    }
+    if !processAnomaly["FAULT_CURRENT"] && !processAnomaly["TEMP_FAULT_CURRENT"] {
+        missing = append(missing, "FAULT_CURRENT or TEMP_FAULT_CURRENT")
+    }
+    return missing
+}
+
+// FcNoBo returns an FC_NO_BO anomaly for every fault current among the anomalies of one feeder that has
+// no BKR_OPEN from before it to after it (both exclusive), as python/anomaly.py does with 1 and 2
+// minutes. Fault currents at the same time give one FC_NO_BO.
+func FcNoBo(anomalies []Anomaly, before time.Duration, after time.Duration) []Anomaly {
+    var opens []int64
+    for _, anomaly := range anomalies {
+        if fcNoBoOpens[anomaly.Anomaly] {
+            opens = append(opens, anomaly.EpochTime)
+        }
+    }
+    sort.Slice(opens, func(i, j int) bool { return opens[i] < opens[j] })
+
+    var fcNoBos []Anomaly
+    seen := make(map[int64]bool)
+    for _, anomaly := range anomalies {
+        if !fcNoBoFaults[anomaly.Anomaly] || seen[anomaly.EpochTime] {
+            continue
+        }
+        from := anomaly.EpochTime - int64(before / time.Second)
+        to   := anomaly.EpochTime + int64(after / time.Second)
+        // first open after from
+        i := sort.Search(len(opens), func(i int) bool { return opens[i] > from })
+        if i < len(opens) && opens[i] < to {
+            continue
+        }
+        seen[anomaly.EpochTime] = true
+        fcNoBo := Anomaly{Id: "0", Anomaly: "FC_NO_BO", DeviceId: "-", DevicePhase: "-", DeviceType: "-",
+            FeederId: anomaly.FeederId, Signal: "-", Value: "-", Time: formatAnomalyTime(time.Unix(anomaly.EpochTime, 0)),
+            EpochTime: anomaly.EpochTime}
+        fcNoBos = append(fcNoBos, fcNoBo)
+    }
+    return fcNoBos
+}
+
+// appendFcNoBo runs FcNoBo on the SCADA anomalies in ofileName, feeder by feeder, and appends the
+// FC_NO_BO anomalies in time order. The output is first cut to the end of the last input file in its
+// manifest, so running it again (e.g. after -resume) replaces the FC_NO_BO anomalies of the last run.
+func appendFcNoBo(ofileName string, before time.Duration, after time.Duration) (int, error) {
+    entries, err := readManifest(manifestPath(ofileName))
+    if err != nil {
+        return 0, err
+    }
+    offset := int64(0)
+    if len(entries) > 0 {
+        offset = entries[len(entries) - 1].Offset
+    }
+    if err = os.Truncate(ofileName, offset); err != nil {
+        return 0, err
+    }
+
+    file, err := os.Open(ofileName)
+    if err != nil {
+        return 0, err
+    }
+    feederAnomalies := make(map[string][]Anomaly)
+    reader := newCSVReader(file)
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            file.Close()
+            return 0, err
+        }
+        if len(record) < 9 {
+            continue
+        }
+        anomaly := new(Anomaly)
+        if err = anomaly.Create(record, anomalyHeader); err != nil {
+            file.Close()
+            return 0, readRowError(ofileName, reader, record, err)
+        }
+        if fcNoBoFaults[anomaly.Anomaly] || fcNoBoOpens[anomaly.Anomaly] {
+            feederAnomalies[anomaly.FeederId] = append(feederAnomalies[anomaly.FeederId], *anomaly)
+        }
+    }
+    file.Close()
+
+    var fcNoBos []Anomaly
+    for _, anomalies := range feederAnomalies {
+        fcNoBos = append(fcNoBos, FcNoBo(anomalies, before, after)...)
+    }
+    sort.SliceStable(fcNoBos, func(i, j int) bool {
+        if fcNoBos[i].EpochTime == fcNoBos[j].EpochTime {
+            return fcNoBos[i].FeederId < fcNoBos[j].FeederId
+        }
+        return fcNoBos[i].EpochTime < fcNoBos[j].EpochTime
+    })
+
+    ofile, err := os.OpenFile(ofileName, os.O_WRONLY | os.O_APPEND, 0644)
+    if err != nil {
+        return 0, err
+    }
+    writer := bufio.NewWriter(ofile)
+    for _, a := range fcNoBos {
+        writer.WriteString(formatCSVRecord(a.Id, a.Anomaly, a.DeviceId, a.DevicePhase, a.DeviceType, a.FeederId, a.Signal,
+            a.Value, a.Time) + "\n")
+    }
+    if err = writer.Flush(); err != nil {
+        ofile.Close()
+        return 0, err
+    }
+    return len(fcNoBos), ofile.Close()
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+    "testing"
+    "time"
+)
+
+func scadaAnomaly(name string, feederId string, tm string) Anomaly {
+    ts, _ := time.Parse("2006-01-02 15:04:05", tm)
+    anomaly := new(Anomaly)
+    anomaly.Populate("0", name, "-", "-", "FEEDER", feederId, "-", "-", ts)
+    return *anomaly
+}
+
+func TestFcNoBo(t *testing.T) {
+    anomalies := []Anomaly{
+        scadaAnomaly("FAULT_CURRENT", "806731", "2016-01-01 10:00:00"),      // open 30s before
+        scadaAnomaly("BKR_OPEN", "806731", "2016-01-01 09:59:30"),
+        scadaAnomaly("TEMP_FAULT_CURRENT", "806731", "2016-01-01 11:00:00"), // open 1m59s after
+        scadaAnomaly("BKR_OPEN", "806731", "2016-01-01 11:01:59"),
+        scadaAnomaly("FAULT_CURRENT", "806731", "2016-01-01 12:00:00"),      // open exactly 2m after
+        scadaAnomaly("FAULT_CURRENT", "806731", "2016-01-01 12:00:00"),      // same time, one FC_NO_BO
+        scadaAnomaly("BKR_OPEN", "806731", "2016-01-01 12:02:00"),
+        scadaAnomaly("INTELI_FAULT_CURRENT", "806731", "2016-01-01 13:00:00"), // open exactly 1m before
+        scadaAnomaly("BKR_OPEN", "806731", "2016-01-01 12:59:00"),
+        scadaAnomaly("BKR_CLOSE", "806731", "2016-01-01 14:00:10"),
+        scadaAnomaly("FAULT_CURRENT", "806731", "2016-01-01 14:00:00"),      // only a close
+    }
+    got := FcNoBo(anomalies, time.Minute, 2 * time.Minute)
+    want := []string{"2016-01-01T12:00:00Z", "2016-01-01T13:00:00Z", "2016-01-01T14:00:00Z"}
+    if len(got) != len(want) {
+        t.Fatalf("got %d FC_NO_BO anomalies %+v, want %d", len(got), got, len(want))
+    }
+    for i, anomaly := range got {
+        if anomaly.Anomaly != "FC_NO_BO" || anomaly.FeederId != "806731" || anomaly.Time != want[i] {
+            t.Errorf("FC_NO_BO %d: got %+v, want time %s", i, anomaly, want[i])
+        }
+    }
+    if got := FcNoBo(anomalies, 2 * time.Hour, 2 * time.Hour); len(got) != 0 {
+        t.Errorf("wide interval: got %+v", got)
+    }
+}
+
+func TestFcNoBoMissing(t *testing.T) {
+    tests := []struct {
+        selection string
+        want      int
+    }{
+        {"FC_NO_BO", 2},
+        {"FC_NO_BO,BKR_OPEN", 1},
+        {"FC_NO_BO,TEMP_FAULT_CURRENT", 1},
+        {"FC_NO_BO,BKR_OPEN,FAULT_CURRENT", 0},
+        {"BKR_OPEN", 0},
+        {"default", 0},
+    }
+    for _, test := range tests {
+        processAnomaly, err := SelectAnomalies("scada", test.selection)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if missing := FcNoBoMissing(processAnomaly); len(missing) != test.want {
+            t.Errorf("%s: got %v, want %d missing", test.selection, missing, test.want)
+        }
+    }
+}
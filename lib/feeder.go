@@ -1,13 +1,13 @@
 package lib
 
 import (
-    "bufio"
-    _ "fmt"
-    _ "io/ioutil"
-    "log"
+    "fmt"
+    "io"
+    "math"
     "os"
     "strconv"
     "strings"
+    "time"
 )
 
 type Feeder struct {
@@ -21,42 +21,106 @@ type Feeder struct {
     Industrial    int64
     FdrOh         float64
     FdrUg         float64
+    Metadata      map[string]string // every column of the metadata file, by header name
 }
 
-func (f *Feeder) Create(feederLine string) {
-    lineComponents  := strings.Split(feederLine, ",")
-    f.FeederId       = lineComponents[1]
-    f.KV             = lineComponents[5]
-    f.Customers, _   = strconv.ParseInt(lineComponents[8], 10, 64)
-    f.Residential, _ = strconv.ParseInt(lineComponents[9], 10, 64)
-    f.Commercial, _  = strconv.ParseInt(lineComponents[10], 10, 64)
-    f.Industrial, _  = strconv.ParseInt(lineComponents[11], 10, 64)
-    f.FdrOh, _       = strconv.ParseFloat(lineComponents[16], 64)
-    f.FdrUg, _       = strconv.ParseFloat(lineComponents[17], 64)
+// Create reads a feeder metadata row. A CUSTOMERS, RESIDENTIAL, COMMERCIAL, INDUSTRIAL, FDR_OH or FDR_UG
+// that is missing or not a number is an error.
+func (f *Feeder) Create(record []string, header CSVHeader) error {
+    f.Metadata       = make(map[string]string)
+    for i, column := range header.Names {
+        if i < len(record) {
+            f.Metadata[column] = record[i]
+        }
+    }
+    f.FeederId       = header.Get(record, "FEEDER")
+    f.InstallDate    = header.Get(record, "INSTALL_DATE")
+    f.KV             = header.Get(record, "KV")
+    var err error
+    if f.Customers, err = parseIntField("CUSTOMERS", header.Get(record, "CUSTOMERS")); err != nil {
+        return err
+    }
+    if f.Residential, err = parseIntField("RESIDENTIAL", header.Get(record, "RESIDENTIAL")); err != nil {
+        return err
+    }
+    if f.Commercial, err = parseIntField("COMMERCIAL", header.Get(record, "COMMERCIAL")); err != nil {
+        return err
+    }
+    if f.Industrial, err = parseIntField("INDUSTRIAL", header.Get(record, "INDUSTRIAL")); err != nil {
+        return err
+    }
+    if f.FdrOh, err = parseFloatField("FDR_OH", header.Get(record, "FDR_OH")); err != nil {
+        return err
+    }
+    f.FdrUg, err = parseFloatField("FDR_UG", header.Get(record, "FDR_UG"))
+    return err
 }
 
-func GetFeederMap(fileName string) map[string]Feeder {
+// GetFeederMap reads the feeder metadata file by feeder. Rows that cannot be read are skipped and added
+// to bad.
+func GetFeederMap(fileName string, bad *BadRows) (map[string]Feeder, error) {
     var feederMap map[string]Feeder = make(map[string]Feeder)
-    if file, err := os.Open(fileName); err == nil {
-        defer file.Close()
-        scanner   := bufio.NewScanner(file)
-        lineCount := 0
-        for scanner.Scan() {
-            line := scanner.Text()
-            if lineCount > 0 {
-                if len(strings.Split(line, ",")) >= 10 {
-                    feederObj := new(Feeder)
-                    feederObj.Create(line)
-                    feederMap[feederObj.FeederId] = *feederObj
-                }
-            }
-            lineCount++
+    file, err := os.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    reader      := newCSVReader(file)
+    header, err := readCSVHeader(fileName, reader, "FEEDER", "KV", "CUSTOMERS", "RESIDENTIAL", "COMMERCIAL",
+        "INDUSTRIAL", "FDR_OH", "FDR_UG")
+    if err != nil {
+        return nil, err
+    }
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("%s: %v", fileName, err)
         }
-        if err = scanner.Err(); err != nil {
-            log.Fatal(err)
+        feederObj := new(Feeder)
+        if len(record) < header.Width {
+            err = shortRowError(record, header)
+        } else {
+            err = feederObj.Create(record, header)
         }
-    } else {
-        log.Fatal(err)
+        if err != nil {
+            if err = bad.Add(readRowError(fileName, reader, record, err)); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        feederMap[feederObj.FeederId] = *feederObj
+    }
+    return feederMap, nil
+}
+
+// Ignored reports feeders left out of signatures: fewer than 100 customers or zero length
+func (f *Feeder) Ignored() bool {
+    return f.Customers < 100 || (f.FdrOh == 0 && f.FdrUg == 0)
+}
+
+// Constant returns a numeric metadata column (True/False read as 1/0), NaN if missing or not numeric
+func (f *Feeder) Constant(column string) float64 {
+    value := strings.TrimSpace(f.Metadata[column])
+    switch strings.ToLower(value) {
+    case "true":
+        return 1
+    case "false":
+        return 0
+    }
+    if v, err := strconv.ParseFloat(value, 64); err == nil {
+        return v
+    }
+    return math.NaN()
+}
+
+// YearsSince returns the whole number of 365-day years between a metadata date column
+// (e.g. HARDENING, "1982-07-18 00:00:00+00:00") and epochTime, NaN if the date is missing
+func (f *Feeder) YearsSince(column string, epochTime int64) float64 {
+    tm, err := time.Parse("2006-01-02 15:04:05-07:00", strings.TrimSpace(f.Metadata[column]))
+    if err != nil {
+        return math.NaN()
     }
-    return feederMap
+    return math.Floor(float64(epochTime - tm.Unix()) / (365 * 24 * 3600))
 }
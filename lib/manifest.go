@@ -0,0 +1,116 @@
+package lib
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+)
+
+// ManifestEntry records one input file finished by an anomaly run. Size and ModTime (local files) or
+// ETag (S3 objects) identify the version of the input; Offset is the size of the output file once the
+// anomalies of the input were written, and Quarantine that of the quarantine file once its bad rows were.
+type ManifestEntry struct {
+    Num        int            `json:"num"`
+    Input      string         `json:"input"`
+    Size       int64          `json:"size"`
+    ModTime    string         `json:"mtime,omitempty"`
+    ETag       string         `json:"etag,omitempty"`
+    Offset     int64          `json:"offset"`
+    Counts     map[string]int `json:"counts"`
+    BadRows    int            `json:"bad_rows,omitempty"`
+    Quarantine int64          `json:"quarantine,omitempty"`
+}
+
+// manifestPath returns the manifest of an output file, <output file>.manifest
+func manifestPath(ofileName string) string {
+    return ofileName + ".manifest"
+}
+
+func newManifestEntry(file inputFile, offset int64, counts map[string]int) ManifestEntry {
+    return ManifestEntry{Num: file.Num, Input: file.Tag, Size: file.Size, ModTime: file.ModTime, ETag: file.ETag,
+        Offset: offset, Counts: counts}
+}
+
+// matches reports whether the entry records this version of file
+func (e *ManifestEntry) matches(file inputFile) bool {
+    return e.Num == file.Num && e.Input == file.Tag && e.Size == file.Size && e.ModTime == file.ModTime && e.ETag == file.ETag
+}
+
+// readManifest reads the entries of a manifest, one JSON object per line. A missing manifest has no
+// entries; a last line cut short by a crash is ignored.
+func readManifest(fileName string) ([]ManifestEntry, error) {
+    file, err := os.Open(fileName)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var entries []ManifestEntry
+    var lineErr error
+    lineNum := 0
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lineNum++
+        if lineErr != nil {
+            return nil, lineErr
+        }
+        var entry ManifestEntry
+        if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+            lineErr = fmt.Errorf("%s:%d: %v", fileName, lineNum, err)
+            continue
+        }
+        entries = append(entries, entry)
+    }
+    return entries, scanner.Err()
+}
+
+// resumeFiles returns the number of files (a prefix, as outputs are written in file order) finished by
+// an earlier run according to its manifest entries, and the output size after them
+func resumeFiles(entries []ManifestEntry, files []inputFile) (int, int64) {
+    done   := 0
+    offset := int64(0)
+    for done < len(entries) && done < len(files) && entries[done].matches(files[done]) {
+        offset = entries[done].Offset
+        done++
+    }
+    return done, offset
+}
+
+// manifestWriter appends an entry for every finished input file
+type manifestWriter struct {
+    file *os.File
+}
+
+// createManifest writes entries to a new manifest, replacing any earlier one
+func createManifest(fileName string, entries []ManifestEntry) (*manifestWriter, error) {
+    file, err := os.Create(fileName)
+    if err != nil {
+        return nil, err
+    }
+    m := &manifestWriter{file: file}
+    for _, entry := range entries {
+        if err = m.add(entry); err != nil {
+            file.Close()
+            return nil, err
+        }
+    }
+    return m, nil
+}
+
+func (m *manifestWriter) add(entry ManifestEntry) error {
+    line, err := json.Marshal(entry)
+    if err != nil {
+        return err
+    }
+    if _, err = m.file.Write(append(line, '\n')); err != nil {
+        return err
+    }
+    return m.file.Sync()
+}
+
+func (m *manifestWriter) Close() error {
+    return m.file.Close()
+}
@@ -3,99 +3,82 @@ package lib
 import (
     "bufio"
     "fmt"
-    "go/build"
-    "io/ioutil"
-    "log"
+    "io"
     "os"
+    "path/filepath"
     "regexp"
     "sort"
     "strconv"
     "strings"
     "time"
+    "github.com/aws/aws-sdk-go/service/s3"
 )
 
-func ProcessAMI(startFileNumber int, endFileNumber int, isBulk bool, isLocal bool) {
+func ProcessAMI(cfg *Config, options RunOptions, isBulk bool, isLocal bool) error {
     var MAX_AMI_KEYS int64 = 100000
-    amiAnomalyCount   := map[string]int{ "LG_PD_10": 0, "LG_PD_10_V2": 0, }
+    processAmiAnomaly, err := SelectAnomalies("ami", cfg.Anomalies.Ami)
+    if err != nil {
+        return err
+    }
+    amiAnomalyCount   := NewAnomalyCount(processAmiAnomaly)
 
-    // Read customer data from csv dump
-    var customerMap map[string]int64 
+    if err := cfg.Require(anomalyRequiredKeys("ami", isBulk, isLocal)...); err != nil {
+        return err
+    }
+    zone, err := cfg.TimeZones.Zone("ami")
+    if err != nil {
+        return err
+    }
 
-    // output file writer - handles AWS/local
-    var writer *bufio.Writer
-    odir := build.Default.GOPATH + "/src/pam/output/"
-    customerMap = readFeederMetadata(build.Default.GOPATH + "/src/pam/data/feeder_metadata.csv")
+    // Read customer data from csv dump
+    metadataBad := NewBadRows(options.MaxBadRows)
+    customerMap, err := readFeederMetadata(cfg.FeederMetadata, metadataBad)
+    if err != nil {
+        return err
+    }
+    if metadataBad.Count() > 0 {
+        fmt.Println(metadataBad.Format(cfg.FeederMetadata))
+    }
 
-    // create output file writer
+    // output file name
     var monthlyOrBulk string
     if isBulk {
         monthlyOrBulk = "bulk"
     } else {
         monthlyOrBulk = "monthly"
     }
-    ofileName := odir + "ami_" + monthlyOrBulk + "_" + strconv.Itoa(startFileNumber) + "_" + strconv.Itoa(endFileNumber) + ".csv"
-    if ofile, err := os.Create(ofileName); err == nil {
-        defer ofile.Close()
-        writer = bufio.NewWriter(ofile)
-    } else {
-        log.Fatal(err)
-    }
+    ofileName := cfg.OutputPath("ami_" + monthlyOrBulk + "_" + strconv.Itoa(options.StartFileNumber) + "_" + strconv.Itoa(options.EndFileNumber) + ".csv")
 
-    startTime := time.Now()
-    fileNum   := 0
+    var files []inputFile
+    var svc *s3.S3
     if ! isBulk {
         if isLocal {
-            dir       := "/Volumes/auto-grid-pam/DISK1/pam-monthly-anomalies"
-            dirs, _   := ioutil.ReadDir(dir)
-            for _, d  := range dirs {
-                monthlyDir := dir + "/" + d.Name()
-                files, _  := ioutil.ReadDir(monthlyDir)
-                for _, f  := range files {
-                    filePath := monthlyDir + "/" + f.Name()
-                    if strings.Contains(f.Name(), ".csv") {
-                        if fileNum >= startFileNumber && (endFileNumber < 0 || fileNum <= endFileNumber) { // && strings.Contains(f.Name(), "803036.csv") {
-                            processAMIFile(filePath, filePath, fileNum, writer, startTime, amiAnomalyCount, customerMap, isBulk)
-                            writer.Flush()
-                        }
-                        fileNum++
-                    }
-                }
-            }
+            files = monthlyFiles(cfg.Input.MonthlyRoot)
         } else { // awsOrLocal == "aws"
-            svc       := GetAWSService("us-west-2")
-            bucket    := "pam-monthly-anomalies"
-            objects   := GetAWSObjectNames(svc, bucket, MAX_AMI_KEYS, "AMI")
-            ofileName := "current_file_" + strconv.Itoa(startFileNumber) + "_" + strconv.Itoa(endFileNumber) + ".csv"
-            fmt.Printf("%d object names retrieved ...\n", len(objects))
-            for _, fileName := range objects {
-                if fileNum >= startFileNumber && (endFileNumber < 0 || fileNum <= endFileNumber) { // && strings.Contains(f.Name(), "803036.csv") {
-                    GetAWSFile(svc, bucket, fileName, ofileName)
-                    processAMIFile(ofileName, fileName, fileNum, writer, startTime, amiAnomalyCount, customerMap, isBulk)
-                    writer.Flush()
-                }
-                fileNum++
-            }
+            svc      = GetAWSService(cfg.Input.S3Region, cfg.Input.S3Profile)
+            objects := GetAWSObjects(svc, cfg.Input.S3Bucket, MAX_AMI_KEYS, "AMI")
+            fmt.Printf("%d objects retrieved ...\n", len(objects))
+            files    = awsFiles(cfg, objects, options.StartFileNumber, options.EndFileNumber)
         }
     } else {
-        dir       := "/Volumes/auto-grid-pam/DISK1/bulk_data/ami"
-        files, _  := ioutil.ReadDir(dir)
-        for _, f  := range files {
-            filePath := dir + "/" + f.Name()
-            if strings.Contains(f.Name(), ".csv") {
-                if fileNum >= startFileNumber && (endFileNumber < 0 || fileNum <= endFileNumber) { // && strings.Contains(f.Name(), "ami_100231.csv") {
-                    processAMIFile(filePath, filePath, fileNum, writer, startTime, amiAnomalyCount, customerMap, isBulk)
-                    writer.Flush()
-                }
-                fileNum++
-            }
-        }
+        files = dirFiles(filepath.Join(cfg.Input.BulkRoot, "ami"), 0, true)
     }
+
+    startTime := time.Now()
+    return runFiles(ofileName, files, options, svc, cfg.Input.S3Bucket, amiAnomalyCount,
+        func(file inputFile, writer *bufio.Writer, counts *AnomalyCount, bad *BadRows) error {
+            return processAMIFile(file.Path, file.Tag, file.Num, writer, startTime, counts, bad, processAmiAnomaly, customerMap, isBulk, zone)
+        })
 }
 
 
-func processAMIFile(fileName string, fileTag string, fileNum int, writer *bufio.Writer,
-    startTime time.Time, anomalyCount map[string]int, customerMap map[string]int64, isBulk bool) {
-    longForm := "2006-01-02 15:04:05"
+// processAMIFile writes the AMI anomalies of a file. Bulk timestamps keep their UTC offset and are cut to
+// the minute; timestamps without an offset are local times in zone. Rows that cannot be read, and last
+// gasp rows without a time, are skipped and added to bad.
+func processAMIFile(fileName string, fileTag string, fileNum int, writer *bufio.Writer, startTime time.Time,
+    anomalyCount *AnomalyCount, bad *BadRows, processAnomaly map[string]bool, customerMap map[string]int64, isBulk bool,
+    zone *TimeZone) error {
+    longForm := "2006-01-02 15:04"
     monthlyLongForm := "1/2/2006 3:04:05 PM"
 	
     // open file
@@ -109,43 +92,69 @@ func processAMIFile(fileName string, fileTag string, fileNum int, writer *bufio.
         var amiObjects []AMI
         hashMap     := make(map[int64]map[string][]AMI)
 
-        mtrTmstmpRegexp, _   := regexp.Compile(`([0-9]{4})-([0-9]{2})-([0-9]{2}) ([0-9]{2}):([0-9]{2}):([0-9]{2})`) // 2014-08-04 12:49:39-04
+        mtrTmstmpRegexp, _   := regexp.Compile(`([0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}):[0-9]{2}(?:\.[0-9]+)?([+-][0-9]{2}(?::?[0-9]{2})?)?`) // 2014-08-04 12:49:39-04
 
-        // create a new scanner and read the file line by line
-        scanner  := bufio.NewScanner(file)
-        for scanner.Scan() {
-            line := scanner.Text()
-            lineComponents := strings.Split(line, ",")
-            if len(lineComponents) >= 11 {
+        // read the file record by record; the header names the columns
+        reader      := newCSVReader(file)
+        header, err := readCSVHeader(fileName, reader, "fdr_num", "ami_dvc_name", "mtr_evnt_id", "mtr_evnt_tmstmp")
+        if err != nil {
+            return err
+        }
+        for {
+            record, err := reader.Read()
+            if err == io.EOF {
+                break
+            } else if err != nil {
+                return fmt.Errorf("%s: %v", fileName, err)
+            }
+            if len(record) < header.Width {
+                if err = bad.Add(readRowError(fileName, reader, record, shortRowError(record, header))); err != nil {
+                    return err
+                }
+            } else {
                 numLines++
 
                 ami              := new(AMI)
 
-                ami.SubstnName    = strings.Replace(lineComponents[0], "\"", "", -1)
-                ami.FdrNum        = strings.Replace(lineComponents[1], "\"", "", -1)
-                ami.PremNum       = strings.Replace(lineComponents[2], "\"", "", -1)
-                ami.PhasType      = strings.Replace(lineComponents[3], "\"", "", -1)
-                ami.CisDvcCoor    = strings.Replace(lineComponents[4], "\"", "", -1)
-                ami.AmiDvcName    = strings.Replace(lineComponents[5], "\"", "", -1)
-                ami.MtrEvntId     = strings.Replace(lineComponents[6], "\"", "", -1)
-                ami.MtrEvntTmstmp = strings.Replace(lineComponents[7], "\"", "", -1)
-                ami.EvntTxt       = strings.Replace(strings.Join(lineComponents[10:len(lineComponents)], ","), "\"", "", -1)
+                ami.SubstnName    = header.Get(record, "SUBSTN_NAME")
+                ami.FdrNum        = header.Get(record, "FDR_NUM")
+                ami.PremNum       = header.Get(record, "PREM_NUM")
+                ami.PhasType      = header.Get(record, "PHAS_TYPE")
+                ami.CisDvcCoor    = header.Get(record, "CIS_DVC_COOR")
+                ami.AmiDvcName    = header.Get(record, "AMI_DVC_NAME")
+                ami.MtrEvntId     = header.Get(record, "MTR_EVNT_ID")
+                ami.MtrEvntTmstmp = header.Get(record, "MTR_EVNT_TMSTMP")
+                ami.EvntTxt       = header.Get(record, "EVNT_TXT")
 
-                modTmstmp := ""
                 if strings.HasPrefix(ami.AmiDvcName, "G") &&
                     (strings.Contains(ami.MtrEvntId, "12007") || strings.Contains(ami.MtrEvntId, "12024")) {
                     numAmiLines++
+                    var evntTs time.Time
                     if isBulk {
+                        // to the minute, as strip_seconds in python/anomaly.py
                         matches := mtrTmstmpRegexp.FindStringSubmatch(ami.MtrEvntTmstmp)
-                        if len(matches) > 0 {
-                            modTmstmp = matches[1] + "-" + matches[2] + "-" + matches[3] + " " + matches[4] + ":" + matches[5] + ":00"
-                            evntTs, _ := time.Parse(longForm, modTmstmp)
-                            ami.MtrEvntEpoch = evntTs.Unix()
+                        if len(matches) == 0 {
+                            err = fmt.Errorf("MTR_EVNT_TMSTMP %q is not a time like %q", ami.MtrEvntTmstmp, "2014-08-04 12:49:39-04")
+                        } else if offset := strings.Replace(matches[2], ":", "", 1); offset != "" {
+                            if len(offset) == 3 {
+                                offset += "00"
+                            }
+                            if evntTs, err = time.Parse(longForm + "-0700", matches[1] + offset); err != nil {
+                                err = fmt.Errorf("MTR_EVNT_TMSTMP %q is not a time like %q", ami.MtrEvntTmstmp, "2014-08-04 12:49:39-04")
+                            }
+                        } else {
+                            evntTs, err = parseTimeField("MTR_EVNT_TMSTMP", longForm, matches[1], zone, true)
                         }
                     } else {
-                        evntTs, _ := time.Parse(monthlyLongForm, ami.MtrEvntTmstmp)
-                        ami.MtrEvntEpoch = evntTs.Unix()
+                        evntTs, err = parseTimeField("MTR_EVNT_TMSTMP", monthlyLongForm, ami.MtrEvntTmstmp, zone, true)
+                    }
+                    if err != nil {
+                        if err = bad.Add(readRowError(fileName, reader, record, err)); err != nil {
+                            return err
+                        }
+                        continue
                     }
+                    ami.MtrEvntEpoch = evntTs.Unix()
                     amiObjects  = append(amiObjects, *ami)
                     if _, ok := hashMap[ami.MtrEvntEpoch]; !ok {
                         hashMap[ami.MtrEvntEpoch] = make(map[string][]AMI)
@@ -159,7 +168,7 @@ func processAMIFile(fileName string, fileTag string, fileNum int, writer *bufio.
             }
         }
         if len(amiObjects) <= 0 {
-            return
+            return nil
         }
 
         sort.Slice(amiObjects, func(i, j int) bool {
@@ -204,15 +213,15 @@ func processAMIFile(fileName string, fileTag string, fileNum int, writer *bufio.
                 }
             }
             gaspCount := len(gaspMeters)
-            if len(nearbyGasps) > 0 || gaspCount > 0 {
+            if processAnomaly["LG_PD_10"] && (len(nearbyGasps) > 0 || gaspCount > 0) {
                 customerCount := customerMap[fdrNum]
                 gaspPct := float64(gaspCount) / float64(customerCount)
                 if gaspPct > 0.1 {
                     // fmt.Printf("len(nearbyGasps): %d, gaspCount: %d, customerCount: %d\n", len(nearbyGasps), gaspCount, customerCount)
                     anom := fmt.Sprintf("LAST GASPS / POWER DOWNS AT %.1f%% OF FEEDER CUSTOMERS (%d METERS)", (100 * gaspPct), gaspCount)
                     ts   := time.Unix(t, 0).UTC()
-                    writer.WriteString(fmt.Sprintf("0,LG_PD_10,-,-,AMI,%s,%s,-,%s\n", fdrNum, anom, ts))
-                    anomalyCount["LG_PD_10"]++;
+                    writer.WriteString(formatCSVRecord("0", "LG_PD_10", "-", "-", "AMI", fdrNum, anom, "-", formatAnomalyTime(ts)) + "\n")
+                    anomalyCount.Inc("LG_PD_10")
                 }
             }
 
@@ -224,50 +233,58 @@ func processAMIFile(fileName string, fileTag string, fileNum int, writer *bufio.
                 }
             }
             gaspCountV2 := len(gaspMetersV2)
-            if gaspCountV2 > 0 {
+            if processAnomaly["LG_PD_10_V2"] && gaspCountV2 > 0 {
                 customerCount := customerMap[fdrNum]
                 gaspPctV2 := float64(gaspCountV2) / float64(customerCount)
                 if gaspPctV2 > 0.1 {
                     anom := fmt.Sprintf("LAST GASPS / POWER DOWNS AT %.1f%% OF FEEDER CUSTOMERS (%d METERS)", (100 * gaspPctV2), gaspCountV2)
                     ts   := time.Unix(t, 0).UTC()
-                    writer.WriteString(fmt.Sprintf("0,LG_PD_10_V2,-,-,AMI,%s,%s,-,%s\n", fdrNum, anom, ts))
-                    anomalyCount["LG_PD_10_V2"]++;
+                    writer.WriteString(formatCSVRecord("0", "LG_PD_10_V2", "-", "-", "AMI", fdrNum, anom, "-", formatAnomalyTime(ts)) + "\n")
+                    anomalyCount.Inc("LG_PD_10_V2")
                 }
             }
             
         }
 
-        anomalyStr := ""
-        for k, v  := range anomalyCount {
-            anomalyStr += ", " + k + ": " + strconv.Itoa(v)
-        }
+        anomalyStr := anomalyCount.Format(processAnomaly)
         elapsed := time.Since(startTime)
-        fmt.Printf("id: %d, fileName: %s, numLines: %d, elapsed: %s%s}\n", fileNum, fileName, numLines, elapsed, anomalyStr)
-        
-        // check for errors
-        if err = scanner.Err(); err != nil {
-            log.Fatal(err)
-        }
-
+        fmt.Printf("id: %d, fileName: %s, numLines: %d, badRows: %d, elapsed: %s%s}\n", fileNum, fileName, numLines, bad.Count(),
+            elapsed, anomalyStr)
+        return nil
     } else {
-        log.Fatal(err)
+        return err
     }
 }
 
-func readFeederMetadata(fileName string) map[string]int64 {
+// readFeederMetadata returns the customers of each feeder in the feeder metadata file. Rows whose
+// CUSTOMERS is not a number are skipped and added to bad.
+func readFeederMetadata(fileName string, bad *BadRows) (map[string]int64, error) {
     customers := make(map[string]int64)
-    if file, err := os.Open(fileName); err == nil {
-        defer file.Close()
-        scanner  := bufio.NewScanner(file)
-        for scanner.Scan() {
-            line := scanner.Text()
-            lineComponents := strings.Split(line, ",")
-            numCustomers, _ := strconv.ParseInt(lineComponents[8], 10, 64)
-            // fmt.Printf("feeder: %s, customers: %s\n", lineComponents[0], lineComponents[8])
-            customers[lineComponents[0]] = numCustomers
+    file, err := os.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    reader      := newCSVReader(file)
+    header, err := readCSVHeader(fileName, reader, "FEEDER", "CUSTOMERS")
+    if err != nil {
+        return nil, err
+    }
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("%s: %v", fileName, err)
         }
-    } else {
-        log.Fatal(err)
+        numCustomers, err := parseIntField("CUSTOMERS", header.Get(record, "CUSTOMERS"))
+        if err != nil {
+            if err = bad.Add(readRowError(fileName, reader, record, err)); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        customers[header.Get(record, "FEEDER")] = numCustomers
     }
-    return customers
+    return customers, nil
 }
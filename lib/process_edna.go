@@ -3,35 +3,34 @@ package lib
 import (
     "bufio"
     "fmt"
-    "go/build"
-    "io/ioutil"
-    "log"
     "math"
-    "os"
-    "regexp"
+    "path/filepath"
     "sort"
     "strconv"
     "strings"
     "time"
+    "github.com/aws/aws-sdk-go/service/s3"
 )
 
-func ProcessEDNA(startFileNumber int, endFileNumber int, isBulk bool, isLocal bool) {
+func ProcessEDNA(cfg *Config, options RunOptions, isBulk bool, isLocal bool, order string) error {
     var MAX_EDNA_KEYS int64 = 100000
-    processEdnaAnomaly := map[string]bool{
-        "AFS_ALARM_ALARM": true,  "AFS_GROUND_ALARM": true, "AFS_I_FAULT_FULL": true, "AFS_I_FAULT_TEMP": true, "AFS_I_FAULT_NEW": true,
-        "FCI_FAULT_ALARM": true,  "FCI_I_FAULT_FULL": true, "FCI_I_FAULT_TEMP": true, "FCI_I_FAULT_NEW":  true,
-        "ZERO_CURRENT_V3": true,  "ZERO_CURRENT_V4":  true,
-        "ZERO_POWER_V3":   true,  "ZERO_POWER_V4":    true,
-        "ZERO_VOLTAGE_V3": true,  "ZERO_VOLTAGE_V4":  true,
-        "PF_SPIKES_V3":    false,  "THD_SPIKES_V3":    true,
-    }
-    var ednaAnomalyCount map[string]int = make(map[string]int)
-    for k, _ := range processEdnaAnomaly {
-        ednaAnomalyCount[k] = 0
+    processEdnaAnomaly, err := SelectAnomalies("edna", cfg.Anomalies.Edna)
+    if err != nil {
+        return err
     }
+    ednaAnomalyCount := NewAnomalyCount(processEdnaAnomaly)
 
-    var writer *bufio.Writer
-    odir := build.Default.GOPATH + "/src/pam/output/"
+    if err := cfg.Require(anomalyRequiredKeys("edna", isBulk, isLocal)...); err != nil {
+        return err
+    }
+    rules, err := GetEdnaRules(cfg.DataDir, cfg.EdnaRulesVersion)
+    if err != nil {
+        return err
+    }
+    zone, err := cfg.TimeZones.Zone("edna")
+    if err != nil {
+        return err
+    }
 
     var monthlyOrBulk string
     if isBulk {
@@ -39,98 +38,44 @@ func ProcessEDNA(startFileNumber int, endFileNumber int, isBulk bool, isLocal bo
     } else {
         monthlyOrBulk = "monthly"
     }
-    ofileName := odir + "edna_" + monthlyOrBulk + "_" + strconv.Itoa(startFileNumber) + "_" + strconv.Itoa(endFileNumber) + ".csv"
-    if ofile, err := os.Create(ofileName); err == nil {
-        defer ofile.Close()
-        writer = bufio.NewWriter(ofile)
-    } else {
-        log.Fatal(err)
-    }
+    ofileName := cfg.OutputPath("edna_" + monthlyOrBulk + "_" + strconv.Itoa(options.StartFileNumber) + "_" + strconv.Itoa(options.EndFileNumber) + ".csv")
 
-    startTime := time.Now()
-    fileNum   := 0
+    var files []inputFile
+    var svc *s3.S3
     if ! isBulk {
         if isLocal {
-            dir       := "/Volumes/auto-grid-pam/DISK1/pam-monthly-anomalies"
-            dirs, _   := ioutil.ReadDir(dir)
-            for _, d  := range dirs {
-                monthlyDir := dir + "/" + d.Name()
-                files, _  := ioutil.ReadDir(monthlyDir)
-                for _, f  := range files {
-                    filePath := monthlyDir + "/" + f.Name()
-                    if strings.Contains(f.Name(), ".csv") {
-                        if fileNum >= startFileNumber && (endFileNumber < 0 || fileNum <= endFileNumber) { // && strings.Contains(f.Name(), "803036.csv") {
-                            processEDNAFile(filePath, filePath, fileNum, writer, startTime, ednaAnomalyCount, processEdnaAnomaly)
-                            writer.Flush()
-                        }
-                        fileNum++
-                    }
-                }
-            }
+            files = monthlyFiles(cfg.Input.MonthlyRoot)
         } else { // ! isLocal i.e. AWS
-            svc       := GetAWSService("us-west-2")
-            bucket    := "pam-monthly-anomalies"
-            objects   := GetAWSObjectNames(svc, bucket, MAX_EDNA_KEYS, "EDNA")
-            ofileName := "current_file_" + strconv.Itoa(startFileNumber) + "_" + strconv.Itoa(endFileNumber) + ".csv"
-            fmt.Printf("%d object names retrieved ...\n", len(objects))
-            for _, fileName := range objects {
-                if fileNum >= startFileNumber && (endFileNumber < 0 || fileNum <= endFileNumber) { // && strings.Contains(f.Name(), "803036.csv") {
-                    GetAWSFile(svc, bucket, fileName, ofileName)
-                    processEDNAFile(ofileName, fileName, fileNum, writer, startTime, ednaAnomalyCount, processEdnaAnomaly)
-                    writer.Flush()
-                }
-                fileNum++
-            }
+            svc      = GetAWSService(cfg.Input.S3Region, cfg.Input.S3Profile)
+            objects := GetAWSObjects(svc, cfg.Input.S3Bucket, MAX_EDNA_KEYS, "EDNA")
+            fmt.Printf("%d objects retrieved ...\n", len(objects))
+            files    = awsFiles(cfg, objects, options.StartFileNumber, options.EndFileNumber)
         }
     } else { // isBulk
-        dir       := "/Volumes/auto-grid-pam/DISK1/bulk_data/edna/response"
-        files, _  := ioutil.ReadDir(dir)
-        for _, f  := range files {
-            filePath := dir + "/" + f.Name()
-            if strings.Contains(f.Name(), ".csv") {
-                if fileNum >= startFileNumber && (endFileNumber < 0 || fileNum <= endFileNumber) { // && strings.Contains(f.Name(), "401636.csv") {
-                    processEDNAFile(filePath, filePath, fileNum, writer, startTime, ednaAnomalyCount, processEdnaAnomaly)
-                    writer.Flush()
-                }
-                fileNum++
-            }
+        files = dirFiles(filepath.Join(cfg.Input.BulkRoot, "edna", "response"), 0, true)
+    }
+
+    if !options.DryRun {
+        if err = writeEdnaRules(ofileName, rules, options.Resume); err != nil {
+            return err
         }
     }
+
+    startTime := time.Now()
+    return runFiles(ofileName, files, options, svc, cfg.Input.S3Bucket, ednaAnomalyCount,
+        func(file inputFile, writer *bufio.Writer, counts *AnomalyCount, bad *BadRows) error {
+            return processEDNAFile(file.Path, file.Tag, file.Num, writer, startTime, counts, bad, processEdnaAnomaly, rules, order, zone)
+        })
 }
 
-func processEDNAFile(fileName string, fileTag string, fileNum int, writer *bufio.Writer,
-	startTime time.Time, anomalyCount map[string]int, processAnomaly map[string]bool) {
+// processEDNAFile writes the eDNA anomalies of a file, with times read in zone. Rows that cannot be read,
+// or whose value a detection needs is not a number, are skipped and added to bad.
+func processEDNAFile(fileName string, fileTag string, fileNum int, writer *bufio.Writer, startTime time.Time,
+    anomalyCount *AnomalyCount, bad *BadRows, processAnomaly map[string]bool, rules *EdnaRules, order string,
+    zone *TimeZone) error {
     oTimeFormat := "01-02 15:04:05"
-    var ednaLines []IndexedEDNA
+    fmt.Printf("[%s] started processing %s\n", time.Now().Format(oTimeFormat), fileTag);
 
-    // read edna file data into ednaLines
-    fmt.Printf("[%s] started reading %s\n", time.Now().Format(oTimeFormat), fileTag);
-    if file, err := os.Open(fileName); err == nil {
-        defer file.Close()
-
-        // create a new scanner and read the file line by line
-        scanner  := bufio.NewScanner(file)
-        for scanner.Scan() {
-            line := scanner.Text()
-            if len(strings.Split(line, ",")) >= 5 {
-                ednaLine := new(IndexedEDNA)
-                ednaLine.Create(line)
-                ednaLines = append(ednaLines, *ednaLine)
-            }
-        }
-        if err = scanner.Err(); err != nil {
-            log.Fatal(err)
-        }
-    } else {
-        log.Fatal(err)
-    }
-    fmt.Printf("[%s] finished reading %d lines from %s\n", time.Now().Format(oTimeFormat), len(ednaLines), fileTag);
-    // Sort data by EpochTimestamp
-    sort.Slice(ednaLines, func(i, j int) bool {
-        return ednaLines[i].EpochTime < ednaLines[j].EpochTime
-    })
-    fmt.Printf("[%s] finished sorting %s\n", time.Now().Format(oTimeFormat), fileTag);
-    
     // create Windows for moving windows
     var zeroCurrentWindows map[string]Window = make(map[string]Window)
     var zeroPowerWindows   map[string]Window = make(map[string]Window)
@@ -138,280 +83,277 @@ func processEDNAFile(fileName string, fileTag string, fileNum int, writer *bufio
     var pfSpikesWindows    map[string]Window = make(map[string]Window)
     var thdSpikesWindows   map[string]Window = make(map[string]Window)
 
-    fdrRegexp, _   := regexp.Compile(`\.([0-9]{6})[\._]`)
-    phaseRegexp, _ := regexp.Compile(`\.([ABC\-])_PH`)
+    // parsed extended IDs, nil for malformed ones
+    signals    := make(map[string]*EdnaSignalID)
+    numBadIds  := 0
+    firstBadId := ""
 
     // init counting, accounting variables/maps
-    var anomalies, filteredAnomalies []Anomaly
+    var anomalies []Anomaly
     var anomalyMap map[string]map[string]int64 = make(map[string]map[string]int64)
     numLines := 0
     for k, _ := range processAnomaly {
         anomalyMap[k] = make(map[string]int64)
     }
 
-    // Process each ednaLine
-    for _, line := range ednaLines {
-        lineComponents := strings.Split(line.EdnaLine, ",")
-        if len(lineComponents) >= 5 {
-            numLines++
+    // filter anomalies with the same extendedId and within rules.DedupSeconds, then write them out. anomalies
+    // holds those of the lines at the current time, sorted by DevicePhase before filtering.
+    flushAnomalies := func() {
+        sort.SliceStable(anomalies, func(i, j int) bool {
+            return anomalies[i].DevicePhase < anomalies[j].DevicePhase
+        })
+        for _, anomaly := range anomalies {
+            anomalyType := anomaly.Anomaly
+            if lastTime, ok := anomalyMap[anomalyType][anomaly.Signal]; !ok || anomaly.EpochTime - lastTime >= rules.DedupSeconds {
+                anomalyMap[anomalyType][anomaly.Signal] = anomaly.EpochTime
+                anomalyCount.Inc(anomalyType)
+                writer.WriteString(fmt.Sprintf("%s\n", anomaly.Format()))
+            }
+        }
+        anomalies = anomalies[:0]
+    }
+
+    // Process each ednaLine, in time order
+    lastEpochTime := int64(0)
+    processLine   := func(line IndexedEDNA) error {
+        if len(anomalies) > 0 && line.EpochTime != lastEpochTime {
+            flushAnomalies()
+        }
+        lastEpochTime = line.EpochTime
+        numLines++
 
-            extendedId  := strings.Replace(lineComponents[0], "\"", "", -1)
-            ts          := line.Time
-            devicePhaseMatches := phaseRegexp.FindStringSubmatch(extendedId)
-            devicePhase := "-"
-            if len(devicePhaseMatches) > 0 {
-                devicePhase = devicePhaseMatches[1]
+        extendedId  := line.ExtendedId
+        ts          := line.Time
+        signal, ok  := signals[extendedId]
+        if !ok {
+            var err error
+            if signal, err = ParseEdnaSignalID(extendedId); err != nil && firstBadId == "" {
+                firstBadId = extendedId
             }
-            feederIdMatches := fdrRegexp.FindStringSubmatch(extendedId)
-            feederId    := ""
-            if len(feederIdMatches) > 0 {
-                feederId = feederIdMatches[1]
+            signals[extendedId] = signal
+        }
+        if signal == nil {
+            numBadIds++
+            return nil
+        }
+        devicePhase := signal.PhaseOrDash()
+        feederId    := signal.Feeder
+        deviceId    := signal.DeviceID
+
+        // the value, which must be a number for the detections that compare it
+        number, err := parseFloatField("VALUE", line.Value)
+        if err != nil {
+            for _, rule := range ednaNumericSignals {
+                if rule.Matches(signal) {
+                    return bad.Add(&RowError{File: fileName, Line: line.Line, Err: err, Record: line.Record()})
+                }
             }
-            
-            if strings.Contains(extendedId, ".AFS.") {
-                // handle potential AFS anomalies
-                deviceId := strings.Split(extendedId, ".")[3]
-                value, _ := strconv.Atoi(strings.Replace(lineComponents[2], "\"", "", -1))
-                valueString := fmt.Sprintf("%d", value)
-                if processAnomaly["AFS_ALARM_ALARM"] && strings.Contains(extendedId, ".ALARM") && strings.Contains(lineComponents[3], "ALARM") {
-                    anomaly     := new(Anomaly)
-                    anomaly.Populate("0", "AFS_ALARM_ALARM", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
-                    anomalies    = append(anomalies, *anomaly)
-                } else if processAnomaly["AFS_GROUND_ALARM"] && strings.Contains(extendedId, ".GROUND") && strings.Contains(lineComponents[3], "ALARM") {
-                    anomaly     := new(Anomaly)
-                    anomaly.Populate("0", "AFS_GROUND_ALARM", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
-                    anomalies    = append(anomalies, *anomaly)
-                } else if (processAnomaly["AFS_I_FAULT_FULL"] || processAnomaly["AFS_I_FAULT_TEMP"]) && strings.Contains(extendedId, ".I_FAULT") {                        
-                    if value >= 600 {
-                        if value >= 900 {
+        }
+
+        if signal.DeviceType == DeviceAFS {
+            // handle potential AFS anomalies
+            value := int(number)
+            valueString := fmt.Sprintf("%d", value)
+            if processAnomaly["AFS_ALARM_ALARM"] && afsAlarmSignals.Matches(signal) && strings.Contains(line.ValueString, "ALARM") {
+                anomaly     := new(Anomaly)
+                anomaly.Populate("0", "AFS_ALARM_ALARM", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
+                anomalies    = append(anomalies, *anomaly)
+            } else if processAnomaly["AFS_GROUND_ALARM"] && afsGroundSignals.Matches(signal) && strings.Contains(line.ValueString, "ALARM") {
+                anomaly     := new(Anomaly)
+                anomaly.Populate("0", "AFS_GROUND_ALARM", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
+                anomalies    = append(anomalies, *anomaly)
+            } else if (processAnomaly["AFS_I_FAULT_FULL"] || processAnomaly["AFS_I_FAULT_TEMP"] || processAnomaly["AFS_I_FAULT_NEW"]) &&
+                afsFaultSignals.Matches(signal) {
+                if value >= rules.FaultCurrent.Temp {
+                    if value >= rules.FaultCurrent.Full {
+                        if processAnomaly["AFS_I_FAULT_FULL"] {
                             anomaly     := new(Anomaly)
                             anomaly.Populate("0", "AFS_I_FAULT_FULL", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
                             anomalies    = append(anomalies, *anomaly)
-                        } else {
-                            anomaly     := new(Anomaly)
-                            anomaly.Populate("0", "AFS_I_FAULT_TEMP", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
-                            anomalies    = append(anomalies, *anomaly)
                         }
-                    }
-                    if value >= 800 {
+                    } else if processAnomaly["AFS_I_FAULT_TEMP"] {
                         anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "AFS_I_FAULT_NEW", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
+                        anomaly.Populate("0", "AFS_I_FAULT_TEMP", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
                         anomalies    = append(anomalies, *anomaly)
                     }
                 }
+                if processAnomaly["AFS_I_FAULT_NEW"] && value >= rules.FaultCurrent.New {
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "AFS_I_FAULT_NEW", deviceId, devicePhase, "AFS", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
+                }
             }
-            
-            if strings.Contains(extendedId, ".FCI.") {
-                // handle potential FCI anomalies
-                value, _    := strconv.Atoi(strings.Replace(lineComponents[2], "\"", "", -1))
-                valueString := fmt.Sprintf("%d", value)
-                deviceId    := strings.Split(extendedId, ".")[3]
-                if processAnomaly["FCI_FAULT_ALARM"] && strings.Contains(extendedId, ".FAULT") && !strings.Contains(lineComponents[3], "NORMAL") {
-                    anomalyCount["FCI_FAULT_ALARM"]++
-                    writer.WriteString(fmt.Sprintf("0,FCI_FAULT_ALARM,%s,%s,FCI,%s,%s,%d,%s\n", deviceId, devicePhase, feederId, extendedId, value, ts))
-                } else if (processAnomaly["FCI_I_FAULT_FULL"] || processAnomaly["FCI_I_FAULT_TEMP"]) && strings.Contains(extendedId, ".I_FAULT") {
-                    if value >= 600 {
-                        deviceId := strings.Split(extendedId, ".")[3]
-                        if value >= 900 {
+        }
+        
+        if signal.DeviceType == DeviceFCI {
+            // handle potential FCI anomalies
+            value       := int(number)
+            valueString := fmt.Sprintf("%d", value)
+            if processAnomaly["FCI_FAULT_ALARM"] && fciAlarmSignals.Matches(signal) && !strings.Contains(line.ValueString, "NORMAL") {
+                anomalyCount.Inc("FCI_FAULT_ALARM")
+                writer.WriteString(formatCSVRecord("0", "FCI_FAULT_ALARM", deviceId, devicePhase, "FCI", feederId, extendedId, valueString, formatAnomalyTime(ts)) + "\n")
+            } else if (processAnomaly["FCI_I_FAULT_FULL"] || processAnomaly["FCI_I_FAULT_TEMP"] || processAnomaly["FCI_I_FAULT_NEW"]) &&
+                fciFaultSignals.Matches(signal) {
+                if value >= rules.FaultCurrent.Temp {
+                    if value >= rules.FaultCurrent.Full {
+                        if processAnomaly["FCI_I_FAULT_FULL"] {
                             anomaly     := new(Anomaly)
                             anomaly.Populate("0", "FCI_I_FAULT_FULL", deviceId, devicePhase, "FCI", feederId, extendedId, valueString, ts)
                             anomalies    = append(anomalies, *anomaly)
-                        } else {
-                            anomaly     := new(Anomaly)
-                            anomaly.Populate("0", "FCI_I_FAULT_TEMP", deviceId, devicePhase, "FCI", feederId, extendedId, valueString, ts)
-                            anomalies    = append(anomalies, *anomaly)
                         }
-                    }
-                    if value >= 800 {
+                    } else if processAnomaly["FCI_I_FAULT_TEMP"] {
                         anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "FCI_I_FAULT_NEW", deviceId, devicePhase, "FCI", feederId, extendedId, valueString, ts)
+                        anomaly.Populate("0", "FCI_I_FAULT_TEMP", deviceId, devicePhase, "FCI", feederId, extendedId, valueString, ts)
                         anomalies    = append(anomalies, *anomaly)
                     }
                 }
-            }
-
-            if (processAnomaly["ZERO_CURRENT_V3"] || processAnomaly["ZERO_CURRENT_V4"]) &&
-                strings.Contains(extendedId, ".I.") && strings.Contains(extendedId, "_PH") &&
-                strings.Contains(extendedId, ".FDR.") && !strings.Contains(extendedId, "BKR.") {
-                value, _ := strconv.ParseFloat(strings.Replace(lineComponents[2], "\"", "", -1), 64)
-                valueString := fmt.Sprintf("%.3f", value)
-                _, ok := zeroCurrentWindows[extendedId]
-                if !ok {
-                    zeroCurrentWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
-                }
-                zeroCurrentWindow := zeroCurrentWindows[extendedId]
-                zeroCurrentWindow.AddElement(ts, extendedId, value)
-                zeroCurrentWindow.SetStartPointer()
-                if value > -0.5 && value < 1 {
-                    deviceId := strings.Split(strings.Split(extendedId, ".")[2], "_")[1]
-                    if processAnomaly["ZERO_CURRENT_V3"] && zeroCurrentWindow.QuantileGreaterThanThreshold(0.01, 10.0, 24) {
-                        anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "ZERO_CURRENT_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
-                        anomalies    = append(anomalies, *anomaly)
-                    }
-                    prevPointer := zeroCurrentWindow.EndPointer - 1
-                    if processAnomaly["ZERO_CURRENT_V4"] && zeroCurrentWindow.GreaterThanThreshold(prevPointer, 1.0) {
-                        anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "ZERO_CURRENT_V4", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
-                        anomalies    = append(anomalies, *anomaly)
-                    }
-
-                    mean := zeroCurrentWindow.Mean()
-                    _ = mean
+                if processAnomaly["FCI_I_FAULT_NEW"] && value >= rules.FaultCurrent.New {
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "FCI_I_FAULT_NEW", deviceId, devicePhase, "FCI", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
                 }
-                zeroCurrentWindows[extendedId] = zeroCurrentWindow
             }
+        }
 
-            if processAnomaly["PF_SPIKES_V3"] &&
-                strings.Contains(extendedId, ".PF.") && strings.Contains(extendedId, "_PH") &&
-                strings.Contains(extendedId, ".FDR.") && !strings.Contains(extendedId, "BKR.") {
-                value, _ := strconv.ParseFloat(strings.Replace(lineComponents[2], "\"", "", -1), 64)
-                _, ok := pfSpikesWindows[extendedId]
-                if !ok {
-                    pfSpikesWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+        if (processAnomaly["ZERO_CURRENT_V3"] || processAnomaly["ZERO_CURRENT_V4"]) && zeroCurrentSignals.Matches(signal) {
+            value := number
+            valueString := fmt.Sprintf("%.3f", value)
+            _, ok := zeroCurrentWindows[extendedId]
+            if !ok {
+                zeroCurrentWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+            }
+            zeroCurrentWindow := zeroCurrentWindows[extendedId]
+            zeroCurrentWindow.AddElement(ts, extendedId, value)
+            zeroCurrentWindow.SetStartPointer()
+            if value > rules.ZeroCurrent.Low && value < rules.ZeroCurrent.High {
+                if processAnomaly["ZERO_CURRENT_V3"] && zeroCurrentWindow.QuantileGreaterThanThreshold(rules.ZeroCurrent.Quantile, rules.ZeroCurrent.Threshold, rules.ZeroCurrent.MinElements) {
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "ZERO_CURRENT_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
                 }
-                pfSpikesWindow := pfSpikesWindows[extendedId]
-                pfSpikesWindow.AddElement(ts, extendedId, math.Abs(value))
-                pfSpikesWindow.SetStartPointer()
-                if math.Abs(value) < 0.75 {
-                    deviceId := strings.Split(strings.Split(extendedId, ".")[2], "_")[1]
-                    _  = deviceId
-                    if pfSpikesWindow.QuantileGreaterThanThreshold(0.01, 0.8, 24) {
-                        valueString := fmt.Sprintf("%.3f", value)
-                        anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "PF_SPIKES_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
-                        anomalies    = append(anomalies, *anomaly)
-                    }
+                prevPointer := zeroCurrentWindow.EndPointer - 1
+                if processAnomaly["ZERO_CURRENT_V4"] && zeroCurrentWindow.GreaterThanThreshold(prevPointer, rules.ZeroCurrent.Previous) {
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "ZERO_CURRENT_V4", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
                 }
-                pfSpikesWindows[extendedId] = pfSpikesWindow
+
+                mean := zeroCurrentWindow.Mean()
+                _ = mean
             }
+            zeroCurrentWindows[extendedId] = zeroCurrentWindow
+        }
 
-            if (processAnomaly["ZERO_POWER_V3"] || processAnomaly["ZERO_POWER_V4"]) &&
-                strings.Contains(extendedId, ".MW") &&
-                strings.Contains(extendedId, ".FDR.") && !strings.Contains(extendedId, "BKR.") {
-                value, _ := strconv.ParseFloat(strings.Replace(lineComponents[2], "\"", "", -1), 64)
-                _, ok := zeroPowerWindows[extendedId]
-                if !ok {
-                    zeroPowerWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
-                }
-                zeroPowerWindow := zeroPowerWindows[extendedId]
-                zeroPowerWindow.AddElement(ts, extendedId, value)
-                zeroPowerWindow.SetStartPointer()
-                if value > -0.5 && value < 0.1 {
-                    deviceId    := "-"
-                    deviceIdArr := strings.Split(strings.Split(extendedId, ".")[2], "_")
-                    if len(deviceIdArr) >= 2 {
-                        deviceId = strings.Split(strings.Split(extendedId, ".")[2], "_")[1]
-                    }
+        if processAnomaly["PF_SPIKES_V3"] && pfSpikesSignals.Matches(signal) {
+            value := number
+            _, ok := pfSpikesWindows[extendedId]
+            if !ok {
+                pfSpikesWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+            }
+            pfSpikesWindow := pfSpikesWindows[extendedId]
+            pfSpikesWindow.AddElement(ts, extendedId, math.Abs(value))
+            pfSpikesWindow.SetStartPointer()
+            if math.Abs(value) < rules.PfSpikes.High {
+                if pfSpikesWindow.QuantileGreaterThanThreshold(rules.PfSpikes.Quantile, rules.PfSpikes.Threshold, rules.PfSpikes.MinElements) {
                     valueString := fmt.Sprintf("%.3f", value)
-                    if zeroPowerWindow.QuantileGreaterThanThreshold(0.01, 0.5, 24) {
-                        anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "ZERO_POWER_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
-                        anomalies    = append(anomalies, *anomaly)
-                    }
-                    prevPointer := zeroPowerWindow.EndPointer - 1
-                    if zeroPowerWindow.GreaterThanThreshold(prevPointer, 0.1) {
-                        anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "ZERO_POWER_V4", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
-                        anomalies    = append(anomalies, *anomaly)
-                    }
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "PF_SPIKES_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
                 }
-                zeroPowerWindows[extendedId] = zeroPowerWindow
             }
+            pfSpikesWindows[extendedId] = pfSpikesWindow
+        }
 
-            if (processAnomaly["ZERO_VOLTAGE_V3"] || processAnomaly["ZERO_VOLTAGE_V4"]) &&
-                strings.Contains(extendedId, ".V.") && strings.Contains(extendedId, "_PH") &&
-                strings.Contains(extendedId, ".FDR.") && !strings.Contains(extendedId, "BKR.") {
-                value, _ := strconv.ParseFloat(strings.Replace(lineComponents[2], "\"", "", -1), 64)
-                _, ok := zeroVoltageWindows[extendedId]
-                if !ok {
-                    zeroVoltageWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+        if (processAnomaly["ZERO_POWER_V3"] || processAnomaly["ZERO_POWER_V4"]) && zeroPowerSignals.Matches(signal) {
+            value := number
+            _, ok := zeroPowerWindows[extendedId]
+            if !ok {
+                zeroPowerWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+            }
+            zeroPowerWindow := zeroPowerWindows[extendedId]
+            zeroPowerWindow.AddElement(ts, extendedId, value)
+            zeroPowerWindow.SetStartPointer()
+            if value > rules.ZeroPower.Low && value < rules.ZeroPower.High {
+                valueString := fmt.Sprintf("%.3f", value)
+                if processAnomaly["ZERO_POWER_V3"] && zeroPowerWindow.QuantileGreaterThanThreshold(rules.ZeroPower.Quantile, rules.ZeroPower.Threshold, rules.ZeroPower.MinElements) {
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "ZERO_POWER_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
                 }
-                zeroVoltageWindow := zeroVoltageWindows[extendedId]
-                zeroVoltageWindow.AddElement(ts, extendedId, value)
-                zeroVoltageWindow.SetStartPointer()
-                if value > -0.5 && value < 1.0 {
-                    valueString := fmt.Sprintf("%.3f", value)
-                    deviceId := strings.Split(strings.Split(extendedId, ".")[2], "_")[1]
-                    if zeroVoltageWindow.QuantileGreaterThanThreshold(0.01, 90.0, 24) {
-                        anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "ZERO_VOLTAGE_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
-                        anomalies    = append(anomalies, *anomaly)
-                    }
-                    prevPointer := zeroVoltageWindow.EndPointer - 1
-                    if zeroVoltageWindow.GreaterThanThreshold(prevPointer, 1.0) {
-                        anomaly     := new(Anomaly)
-                        anomaly.Populate("0", "ZERO_VOLTAGE_V4", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
-                        anomalies    = append(anomalies, *anomaly)
-                    }
+                prevPointer := zeroPowerWindow.EndPointer - 1
+                if processAnomaly["ZERO_POWER_V4"] && zeroPowerWindow.GreaterThanThreshold(prevPointer, rules.ZeroPower.Previous) {
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "ZERO_POWER_V4", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
                 }
-                zeroVoltageWindows[extendedId] = zeroVoltageWindow
             }
+            zeroPowerWindows[extendedId] = zeroPowerWindow
+        }
 
-            if processAnomaly["THD_SPIKES_V3"] && strings.Contains(extendedId, ".THD_") && strings.Contains(extendedId, "urrent") {
-                value, _ := strconv.ParseFloat(strings.Replace(lineComponents[2], "\"", "", -1), 64)
-                _, ok := thdSpikesWindows[extendedId]
-                if !ok {
-                    thdSpikesWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+        if (processAnomaly["ZERO_VOLTAGE_V3"] || processAnomaly["ZERO_VOLTAGE_V4"]) && zeroVoltageSignals.Matches(signal) {
+            value := number
+            _, ok := zeroVoltageWindows[extendedId]
+            if !ok {
+                zeroVoltageWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+            }
+            zeroVoltageWindow := zeroVoltageWindows[extendedId]
+            zeroVoltageWindow.AddElement(ts, extendedId, value)
+            zeroVoltageWindow.SetStartPointer()
+            if value > rules.ZeroVoltage.Low && value < rules.ZeroVoltage.High {
+                valueString := fmt.Sprintf("%.3f", value)
+                if processAnomaly["ZERO_VOLTAGE_V3"] && zeroVoltageWindow.QuantileGreaterThanThreshold(rules.ZeroVoltage.Quantile, rules.ZeroVoltage.Threshold, rules.ZeroVoltage.MinElements) {
+                    anomaly     := new(Anomaly)
+                    anomaly.Populate("0", "ZERO_VOLTAGE_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                    anomalies    = append(anomalies, *anomaly)
                 }
-                thdSpikesWindow := thdSpikesWindows[extendedId]
-                thdSpikesWindow.AddElement(ts, extendedId, value)
-                thdSpikesWindow.SetStartPointer()
-                mean      := thdSpikesWindow.Mean()
-                stdDev    := thdSpikesWindow.StdDeviation()
-                threshold := mean + 7.0 * stdDev
-                if value > threshold {
-                    valueString := fmt.Sprintf("%.3f", value)
-                    deviceId := strings.Split(strings.Split(extendedId, ".")[2], "_")[1]
+                prevPointer := zeroVoltageWindow.EndPointer - 1
+                if processAnomaly["ZERO_VOLTAGE_V4"] && zeroVoltageWindow.GreaterThanThreshold(prevPointer, rules.ZeroVoltage.Previous) {
                     anomaly     := new(Anomaly)
-                    anomaly.Populate("0", "THD_SPIKES_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                    anomaly.Populate("0", "ZERO_VOLTAGE_V4", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
                     anomalies    = append(anomalies, *anomaly)
                 }
-                thdSpikesWindows[extendedId] = thdSpikesWindow
             }
+            zeroVoltageWindows[extendedId] = zeroVoltageWindow
+        }
 
-            if numLines % 1000000 == 0 {
-                fmt.Printf("[%s]\t\tprocessed %d lines\n", time.Now().Format(oTimeFormat), numLines)
+        if processAnomaly["THD_SPIKES_V3"] && thdSpikesSignals.Matches(signal) {
+            value := number
+            _, ok := thdSpikesWindows[extendedId]
+            if !ok {
+                thdSpikesWindows[extendedId] = Window{StartPointer: 0, EndPointer: -1, MAXSIZE: 1000}
+            }
+            thdSpikesWindow := thdSpikesWindows[extendedId]
+            thdSpikesWindow.AddElement(ts, extendedId, value)
+            thdSpikesWindow.SetStartPointer()
+            mean      := thdSpikesWindow.Mean()
+            stdDev    := thdSpikesWindow.StdDeviation()
+            threshold := mean + rules.ThdSpikes.Sigmas * stdDev
+            if value > threshold {
+                valueString := fmt.Sprintf("%.3f", value)
+                anomaly     := new(Anomaly)
+                anomaly.Populate("0", "THD_SPIKES_V3", deviceId, devicePhase, "PHASER", feederId, extendedId, valueString, ts)
+                anomalies    = append(anomalies, *anomaly)
             }
+            thdSpikesWindows[extendedId] = thdSpikesWindow
         }
-    }
 
-    // sort anomalies by EpochTime, then DevicePhase
-    sort.Slice(anomalies, func(i, j int) bool {
-        if anomalies[i].EpochTime == anomalies[j].EpochTime {
-            return (strings.Compare(anomalies[i].DevicePhase, anomalies[j].DevicePhase) <= 0)
-        } else {
-            return anomalies[i].EpochTime < anomalies[j].EpochTime
-        }
-    })
-    // filter anomalies with the same extendedId and within 3 minutes
-    for _, anomaly := range anomalies {
-        anomalyType := anomaly.Anomaly
-        if _, ok := anomalyMap[anomalyType][anomaly.Signal]; !ok {
-            anomalyMap[anomalyType][anomaly.Signal] = anomaly.EpochTime
-            filteredAnomalies = append(filteredAnomalies, anomaly)
-            anomalyCount[anomalyType]++
-        } else {
-            if anomaly.EpochTime - anomalyMap[anomalyType][anomaly.Signal] >= 180 { // difference is > 3 minutes
-                anomalyMap[anomalyType][anomaly.Signal] = anomaly.EpochTime
-                filteredAnomalies = append(filteredAnomalies, anomaly)
-                anomalyCount[anomalyType]++
-            }
+        if numLines % 1000000 == 0 {
+            fmt.Printf("[%s]\t\tprocessed %d lines\n", time.Now().Format(oTimeFormat), numLines)
         }
+        return nil
     }
 
-    // write out filtered anomalies
-    for _, anomaly := range filteredAnomalies {
-        writer.WriteString(fmt.Sprintf("%s\n", anomaly.Format()))
+    if err := readEDNA(fileName, order, zone, bad, processLine); err != nil {
+        return err
     }
-    
-    anomalyStr := ""
-    for k, v  := range anomalyCount {
-        if processAnomaly[k] {
-            anomalyStr += ", " + k + ": " + strconv.Itoa(v)
-        }
+    flushAnomalies()
+
+    if numBadIds > 0 {
+        fmt.Printf("[%s] %s: skipped %d lines with malformed extended IDs, e.g. %q\n", time.Now().Format(oTimeFormat), fileTag, numBadIds, firstBadId)
     }
+    anomalyStr := anomalyCount.Format(processAnomaly)
     elapsed := time.Since(startTime)
-    fmt.Printf("[%s] {id: %d, filePath: \"%s\", numLines: %d, elapsed: %s%s}\n", time.Now().Format(oTimeFormat), fileNum, fileTag, numLines, elapsed, anomalyStr)
+    fmt.Printf("[%s] {id: %d, filePath: \"%s\", numLines: %d, badIds: %d, badRows: %d, elapsed: %s%s}\n", time.Now().Format(oTimeFormat), fileNum, fileTag, numLines, numBadIds, bad.Count(), elapsed, anomalyStr)
+    return nil
 }
 
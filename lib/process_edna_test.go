@@ -0,0 +1,108 @@
+package lib
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+// ednaSelectionFixture writes an eDNA file with AFS and FCI fault currents over the full, temp and new
+// thresholds and power and voltage signals that drop to zero after a day of normal values
+func ednaSelectionFixture(t *testing.T, dir string) string {
+    var data bytes.Buffer
+    data.WriteString("Extended Id,Time,Value,ValueString,Status\n")
+    start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+    line  := func(id string, tm time.Time, value string) {
+        fmt.Fprintf(&data, "%s,%s,%s,%s,OK\n", id, tm.Format("1/2/2006 3:04:05 PM"), value, value)
+    }
+    for i := 0; i < 48; i++ {
+        tm := start.Add(time.Duration(i) * 30 * time.Minute)
+        line("SUB.FDR.123456_D4.MW", tm, "5.0")
+        line("SUB.FDR.123456_D2.V.C_PH", tm, "120.0")
+    }
+    end := start.Add(24 * time.Hour)
+    line("SUB.FDR.123456_D4.MW", end, "0.0")
+    line("SUB.FDR.123456_D2.V.C_PH", end, "0.0")
+    line("SUB.123456.AFS.A3.I_FAULT.C_PH", end.Add(10 * time.Minute), "950")
+    line("SUB.123456.AFS.A3.I_FAULT.C_PH", end.Add(20 * time.Minute), "700")
+    line("SUB.123456.FCI.DEV8.I_FAULT.B_PH", end.Add(30 * time.Minute), "950")
+    line("SUB.123456.FCI.DEV8.I_FAULT.B_PH", end.Add(40 * time.Minute), "700")
+
+    fileName := filepath.Join(dir, "123456.csv")
+    if err := ioutil.WriteFile(fileName, data.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return fileName
+}
+
+// processEDNASelection returns the anomaly types processEDNAFile writes for a selection, and those it counts
+func processEDNASelection(t *testing.T, fileName string, selection string) (map[string]bool, map[string]bool) {
+    processAnomaly, err := SelectAnomalies("edna", selection)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rules, err := GetEdnaRules("../data", "1_0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var out bytes.Buffer
+    writer := bufio.NewWriter(&out)
+    counts := NewAnomalyCount(processAnomaly)
+    err     = processEDNAFile(fileName, fileName, 0, writer, time.Now(), counts, nil, processAnomaly, rules,
+        EdnaOrderSorted, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    writer.Flush()
+    written := make(map[string]bool)
+    for _, record := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+        if fields := strings.Split(record, ","); len(fields) > 1 {
+            written[fields[1]] = true
+        }
+    }
+    counted := make(map[string]bool)
+    for name, count := range counts.Counts() {
+        if count > 0 {
+            counted[name] = true
+        }
+    }
+    return written, counted
+}
+
+func TestProcessEDNASelection(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pam_edna_selection_")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    fileName := ednaSelectionFixture(t, dir)
+
+    all, _ := processEDNASelection(t, fileName, "all")
+    for _, name := range []string{"AFS_I_FAULT_FULL", "AFS_I_FAULT_TEMP", "AFS_I_FAULT_NEW", "FCI_I_FAULT_FULL",
+        "FCI_I_FAULT_TEMP", "FCI_I_FAULT_NEW", "ZERO_POWER_V3", "ZERO_POWER_V4", "ZERO_VOLTAGE_V3", "ZERO_VOLTAGE_V4"} {
+        if !all[name] {
+            t.Errorf("all: no %s in %v", name, all)
+        }
+    }
+
+    for _, selection := range []string{"default", "AFS_I_FAULT_NEW,ZERO_VOLTAGE_V3", "FCI_I_FAULT_TEMP,ZERO_POWER_V4"} {
+        processAnomaly, _ := SelectAnomalies("edna", selection)
+        want := make(map[string]bool)
+        for name := range all {
+            if processAnomaly[name] {
+                want[name] = true
+            }
+        }
+        written, counted := processEDNASelection(t, fileName, selection)
+        if !reflect.DeepEqual(written, want) || !reflect.DeepEqual(counted, want) {
+            t.Errorf("%s: wrote %v and counted %v, want %v", selection, written, counted, want)
+        }
+    }
+}
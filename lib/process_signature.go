@@ -2,75 +2,92 @@ package lib
 
 import (
     "fmt"
-    _ "io/ioutil"
-    _ "os"
-    _ "regexp"
-    "sort"
-    _ "strconv"
-    _ "strings"
+    "strings"
 )
 
-func ProcessSignature() {
-    homeDir    := "/Users/sanjaynoronha/go/src/pam"
-    anomalyMap := GetAnomalyMap(2) // seed data mapping anomalies types
-    feederMap  := GetFeederMap(homeDir + "/data/feeder_metadata.csv")
-    datasetMap := GetDatasetMap(homeDir + "/data/pam_1_0_dataset.csv")
+// ProcessSignature builds signatures from the anomalies file and labels them with the hours to the
+// next ticketed outage, looking at most maxLookahead hours before and maxLookback hours after it.
+// Writes signatures_<dataset_version>.csv, .parquet and .json (schema sidecar) to the output directory,
+// .rejected_tickets.csv with the ticket rows that label no signature and why, and .quarantine.csv with
+// the rows of the inputs that could not be read. An input with more than max_bad_rows of those fails.
+func ProcessSignature(cfg *Config, maxLookahead float64, maxLookback float64) error {
+    if err := cfg.Require(signatureRequiredKeys...); err != nil {
+        return err
+    }
+    anomalyMap, err := GetAnomalyMap(cfg.DataDir, cfg.AnomalyMapVersion) // seed data mapping anomalies types
+    if err != nil {
+        return err
+    }
+    ticketZone, err := cfg.TimeZones.Zone("tickets")
+    if err != nil {
+        return err
+    }
+    ticketFilter, err := cfg.Tickets.Filter(ticketZone.Location)
+    if err != nil {
+        return err
+    }
+    maxBadRows, err := cfg.BadRowLimit()
+    if err != nil {
+        return err
+    }
+    var badRows []*RowError
+    feederBad := NewBadRows(maxBadRows)
+    feederMap, err := GetFeederMap(cfg.FeederMetadata, feederBad)
+    if err != nil {
+        return err
+    }
+    if feederBad.Count() > 0 {
+        fmt.Println(feederBad.Format(cfg.FeederMetadata))
+    }
+    badRows = append(badRows, feederBad.Rows...)
+    dataset, err := GetDataset(cfg.DataDir, cfg.DatasetVersion)
+    if err != nil {
+        return err
+    }
+    if unused := anomalyMap.UnusedTargets(dataset); len(unused) > 0 {
+        fmt.Printf("%s: ignoring targets no dataset column looks up: %s\n", anomalyMap.FileName, strings.Join(unused, ", "))
+    }
+    transformer, err := NewSignatureTransformer(dataset, anomalyMap, feederMap)
+    if err != nil {
+        return err
+    }
+    anomalyBad := NewBadRows(maxBadRows)
+    anomalies, err := GetAnomalies(cfg.Input.AnomaliesFile, anomalyBad)
+    if err != nil {
+        return err
+    }
+    if anomalyBad.Count() > 0 {
+        fmt.Println(anomalyBad.Format(cfg.Input.AnomaliesFile))
+    }
+    badRows = append(badRows, anomalyBad.Rows...)
+    transformer.Transform(anomalies)
     fmt.Printf("Started tickets ...\n")
-    ticketMap  := GetTicketMap(homeDir + "/data/tickets")
-    fmt.Printf("Finished tickets ...\n")
-    _ = anomalyMap
-    anomalies := GetAnomalies(homeDir + "/data/all_anoms.csv")
-    TruncateAnomalyTimes(anomalies)
-    var sortedFeederIds []string
-    for feederId, _ := range anomalies {
-        sortedFeederIds = append(sortedFeederIds, feederId)
-    }
-    sort.Strings(sortedFeederIds)
-    var y []YObject   = make([]YObject, 0)
-    for _, feederId := range sortedFeederIds {
-        if len(ticketMap[feederId]) > 0 { // && feederId == "808931" {
-            y, _ = transformIntoSignatures(y, anomalies[feederId], ticketMap[feederId], datasetMap, feederMap[feederId], feederId)
-        }
-    }
-    fmt.Printf("Length of y: %d\n", len(y))
-}
-
-// anomalies:  map[FeederId]:    [Anomaly1, Anomaly2, ... Anomalyn]
-// ticketMap:  map[FeederId]:    [Ticket1, Ticket2, ... Ticketn]
-// datasetMap: map[AnomalyType]: [DatasetObject1, DatasetObject2, ... DatasetObjectn]
-// feederMap:  map[FeederId]:    [Feeder1, Feeder2, ... Feedern]
-func transformIntoSignatures(y []YObject, fAnomalies []Anomaly, fTickets []Ticket, datasetMap map[string]DatasetObject,
-    feeder Feeder, feederId string) ([]YObject, []YObject) {
-    // fmt.Printf("%s: anomalies:\t%d\ttickets: %d\n", feederId, len(fAnomalies), len(fTickets))
+    ticketMap, ticketReport, err := GetTicketMap(cfg.Input.TicketsDir, ticketFilter, ticketZone, maxBadRows)
+    if err != nil {
+        return err
+    }
+    badRows = append(badRows, ticketReport.BadRows...)
+    fmt.Printf("Finished tickets %s\n", ticketReport.Format())
+    transformer.AddTarget(ticketMap, maxLookahead, maxLookback)
+    fmt.Printf("Length of y: %d\n", len(transformer.Y))
 
-    // Get unique trigger times
-    var timeMap map[int64]bool = make(map[int64]bool, 0)
-    var times int64arr = make([]int64, 0)
-    for _, anom := range fAnomalies {
-        timeMap[anom.EpochTime] = true
+    baseName := cfg.OutputPath("signatures_" + cfg.DatasetVersion)
+    if err = transformer.WriteCSV(baseName + ".csv"); err != nil {
+        return err
     }
-    for ts, _ := range timeMap {
-        times  = append(times, ts)
+    if err = transformer.WriteParquet(baseName + ".parquet"); err != nil {
+        return err
     }
-    sort.Sort(times)
-
-    for _, t := range times {
-        yObj := new(YObject)
-        yObj.Feeder    = feederId
-        yObj.Timestamp = t
-        y     = append(y, *yObj)
+    if err = transformer.WriteSchema(baseName + ".json", cfg.DatasetVersion); err != nil {
+        return err
     }
-
-    for _, ticket := range fTickets {
-        // fmt.Printf("TICKET: %s %d\n", ticket.PowerOff, ticket.PowerOffEpoch)
-        count := 0
-        for _, anomaly := range fAnomalies {
-            diffTime := ticket.PowerOffEpoch - anomaly.EpochTime
-            if diffTime > 0 && diffTime < 15 * 24 * 3600 {
-                // fmt.Printf("\tANOMALY: %s %s %d\n", anomaly.Anomaly, anomaly.Time, anomaly.EpochTime)
-                count++
-            }
-        }
-    }
-    return y, y
+    if err = ticketReport.WriteRejects(baseName + ".rejected_tickets.csv"); err != nil {
+        return err
+    }
+    if err = WriteQuarantine(baseName + ".quarantine.csv", badRows); err != nil {
+        return err
+    }
+    fmt.Printf("Wrote %s.csv, %s.parquet, %s.json, %s.rejected_tickets.csv and %s.quarantine.csv (%d bad rows)\n",
+        baseName, baseName, baseName, baseName, baseName, len(badRows))
+    return nil
 }
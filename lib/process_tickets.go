@@ -0,0 +1,144 @@
+package lib
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Interruption types of the tickets TicketAnomalies uses, and of the tickets that are lateral outages
+var (
+    ticketAnomalyTypes = map[string]bool{"OCR": true, "LAT": true, "FDR": true}
+    lateralOutageTypes = map[string]bool{"OCR": true, "LAT": true}
+)
+
+func ProcessTickets(cfg *Config, options RunOptions) error {
+    processTicketAnomaly, err := SelectAnomalies("tickets", cfg.Anomalies.Tickets)
+    if err != nil {
+        return err
+    }
+    ticketAnomalyCount := NewAnomalyCount(processTicketAnomaly)
+
+    if err := cfg.Require(anomalyRequiredKeys("tickets", true, true)...); err != nil {
+        return err
+    }
+    zone, err := cfg.TimeZones.Zone("tickets")
+    if err != nil {
+        return err
+    }
+
+    ofileName := cfg.OutputPath("tickets_" + strconv.Itoa(options.StartFileNumber) + "_" + strconv.Itoa(options.EndFileNumber) + ".csv")
+
+    var files []inputFile
+    for _, file := range dirFiles(cfg.Input.TicketsDir, 0, true) {
+        if strings.Contains(file.Path[strings.LastIndex(file.Path, "/") + 1:], "TICKETS") {
+            file.Num = len(files)
+            files    = append(files, file)
+        }
+    }
+
+    // a tickets file holds every feeder, so -feeders selects tickets rather than files
+    feeders := options.Select.Feeders
+    options.Select.Feeders = nil
+
+    startTime := time.Now()
+    return runFiles(ofileName, files, options, nil, "", ticketAnomalyCount,
+        func(file inputFile, writer *bufio.Writer, counts *AnomalyCount, bad *BadRows) error {
+            return processTicketsFile(file.Path, file.Num, writer, startTime, counts, bad, processTicketAnomaly, feeders, zone)
+        })
+}
+
+// processTicketsFile writes the ticket anomalies of a tickets file, as TicketAnomalies in python/anomaly.py:
+// RE_FUSE_ONLY for tickets of one row with a Refuse repair action, at POWERRESTORE, and LATERAL_OUTAGES
+// for OCR and LAT tickets, once per POWEROFF. The rows of a ticket are counted within the file. Rows that
+// cannot be read are skipped and added to bad; a ticket without a POWERRESTORE has no RE_FUSE_ONLY. Times
+// are read in zone.
+func processTicketsFile(fileName string, fileNum int, writer *bufio.Writer, startTime time.Time,
+    anomalyCount *AnomalyCount, bad *BadRows, processAnomaly map[string]bool, feeders map[string]bool, zone *TimeZone) error {
+    // open file
+    file, err := os.Open(fileName)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    numLines    := 0
+    var tickets []Ticket
+    ticketRows  := make(map[string]int)
+
+    reader      := newCSVReader(file)
+    header, err := readCSVHeader(fileName, reader, "DW_TCKT_KEY", "FDR_NUM", "IRPT_TYPE_CODE", "POWEROFF",
+        "POWERRESTORE", "RPR_ACTN_TYPE")
+    if err != nil {
+        return err
+    }
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return fmt.Errorf("%s: %v", fileName, err)
+        }
+        numLines++
+        ticket := new(Ticket)
+        if len(record) < header.Width {
+            err = shortRowError(record, header)
+        } else {
+            err = ticket.Create(record, header, zone)
+        }
+        if err != nil {
+            if err = bad.Add(readRowError(fileName, reader, record, err)); err != nil {
+                return err
+            }
+            continue
+        }
+        if !ticketAnomalyTypes[ticket.IrptTypeCode] || (len(feeders) > 0 && !feeders[ticket.FeederNumber]) {
+            continue
+        }
+        tickets = append(tickets, *ticket)
+        ticketRows[ticket.FeederNumber + "," + ticket.TicketKey]++
+    }
+
+    var anomalies []Anomaly
+    seen := make(map[string]bool)
+    for _, ticket := range tickets {
+        if processAnomaly["RE_FUSE_ONLY"] && ticketRows[ticket.FeederNumber + "," + ticket.TicketKey] == 1 &&
+            strings.Contains(ticket.RprActionType, "Refuse") && !ticket.PowerRestore.IsZero() {
+            anomalies = append(anomalies, ticketAnomaly("RE_FUSE_ONLY", &ticket, ticket.PowerRestore))
+        }
+        key := ticket.FeederNumber + "," + ticket.TicketKey + "," + ticket.PowerOff.String()
+        if processAnomaly["LATERAL_OUTAGES"] && lateralOutageTypes[ticket.IrptTypeCode] && !seen[key] {
+            seen[key] = true
+            anomalies = append(anomalies, ticketAnomaly("LATERAL_OUTAGES", &ticket, ticket.PowerOff))
+        }
+    }
+    sort.SliceStable(anomalies, func(i, j int) bool {
+        if anomalies[i].EpochTime == anomalies[j].EpochTime {
+            return anomalies[i].Signal < anomalies[j].Signal
+        }
+        return anomalies[i].EpochTime < anomalies[j].EpochTime
+    })
+    for _, anomaly := range anomalies {
+        anomalyCount.Inc(anomaly.Anomaly)
+        writer.WriteString(anomaly.Format() + "\n")
+    }
+
+    anomalyStr := anomalyCount.Format(processAnomaly)
+
+    elapsed := time.Since(startTime)
+    fmt.Printf("{id: %d, filePath: \"%s\", numLines: %d, badRows: %d, elapsed: %s%s}\n", fileNum, fileName,
+        numLines, bad.Count(), elapsed, anomalyStr)
+    return nil
+}
+
+// ticketAnomaly is an anomaly of a ticket: its signal is the ticket key, it has no device ID or phase
+func ticketAnomaly(name string, ticket *Ticket, tm time.Time) Anomaly {
+    var anomaly Anomaly
+    anomaly.Populate("0", name, "-", "-", "TICKETS", ticket.FeederNumber, ticket.TicketKey, "-", tm)
+    return anomaly
+}
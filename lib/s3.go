@@ -10,19 +10,25 @@ import (
     "github.com/aws/aws-sdk-go/aws/session"
 )
 
-func GetAWSService(region string) *s3.S3 {
+func GetAWSService(region string, profile string) *s3.S3 {
     sess := session.Must(session.NewSessionWithOptions(session.Options{
-        Config: aws.Config{Region: aws.String("us-west-2")},
-        Profile: "fpl_user",
+        Config: aws.Config{Region: aws.String(region)},
+        Profile: profile,
     }))
     svc  := s3.New(sess)
     return svc
 }
 
-func GetAWSObjectNames(svc *s3.S3, bucket string, maxKeys int64, prefix string) []string {
-    var objectsMap map[string]int = make(map[string]int)
-    _ = objectsMap
-    objects    := make([]string, 0)
+// AWSObject is an S3 object listed by GetAWSObjects
+type AWSObject struct {
+    Key  string
+    Size int64
+    ETag string
+}
+
+func GetAWSObjects(svc *s3.S3, bucket string, maxKeys int64, prefix string) []AWSObject {
+    var objectsMap map[string]AWSObject = make(map[string]AWSObject)
+    objects    := make([]AWSObject, 0)
     done       := false
     marker     := ""
     fmt.Printf("Computing total number of AWS %s objects ...\n", prefix)	
@@ -35,36 +41,39 @@ func GetAWSObjectNames(svc *s3.S3, bucket string, maxKeys int64, prefix string)
         }
         for _, key := range resp.Contents {
             marker  = *key.Key
-            objectsMap[marker] = 0
+            objectsMap[marker] = AWSObject{Key: marker, Size: aws.Int64Value(key.Size), ETag: aws.StringValue(key.ETag)}
         }
         if !aws.BoolValue(resp.IsTruncated) {
             done = true
         }
     }
-    for obj := range objectsMap {
+    for _, obj := range objectsMap {
         objects = append(objects, obj)
     }
-    sort.Strings(objects)
+    sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
     fmt.Printf("Total Number of AWS %s objects is %d\n", prefix, len(objects))
     return objects
 }
 
-func GetAWSFile(svc *s3.S3, bucket string, fileName string, ofileName string) {
-    // fmt.Println("Loading " + fileName)
+// GetAWSFile downloads an S3 object to ofileName. On an error the partial download is removed.
+func GetAWSFile(svc *s3.S3, bucket string, fileName string, ofileName string) error {
     object, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(fileName),})
     if err != nil {
-        fmt.Println(err.Error())
-        return
+        return fmt.Errorf("downloading %s: %v", fileName, err)
     }
+    defer object.Body.Close()
     file, err := os.Create(ofileName)
     if err != nil {
-        fmt.Println(err.Error())
-        return
+        return err
+    }
+    if _, err = io.Copy(file, object.Body); err != nil {
+        file.Close()
+        os.Remove(ofileName)
+        return fmt.Errorf("downloading %s: %v", fileName, err)
     }
-    if _, err := io.Copy(file, object.Body); err != nil {
-        fmt.Println(err.Error())
-        return
+    if err = file.Close(); err != nil {
+        os.Remove(ofileName)
+        return err
     }
-    object.Body.Close()
-    file.Close()
+    return nil
 }
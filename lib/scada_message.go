@@ -0,0 +1,353 @@
+package lib
+
+import (
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// ScadaMessage is a tokenized SCADA observation (OBSERV_DATA), e.g. "STN FEEDER 806731 AAMP LIM-HIGH 950":
+// station, device type, device ID, point, state and value tokens. Missing tokens are "-".
+type ScadaMessage struct {
+    Text       string
+    Tokens     []string
+    DeviceType string // token 1
+    DeviceID   string // token 2
+    Point      string // token 3, the point name, which holds the phase of most families
+    State      string // token 4, e.g. OPEND-CLOSED-OPEND for breakers
+}
+
+// ParseScadaMessage tokenizes the text of a SCADA observation on spaces, as get_ith in python/anomaly.py
+func ParseScadaMessage(text string) *ScadaMessage {
+    m := &ScadaMessage{Text: text, Tokens: strings.Split(text, " ")}
+    m.DeviceType = m.Token(1)
+    m.DeviceID   = m.Token(2)
+    m.Point      = m.Token(3)
+    m.State      = m.Token(4)
+    return m
+}
+
+// Token returns token i of the message, "-" if it is missing
+func (m *ScadaMessage) Token(i int) string {
+    if i < len(m.Tokens) {
+        return m.Tokens[i]
+    }
+    return "-"
+}
+
+// HasToken reports whether word is a token of the message
+func (m *ScadaMessage) HasToken(word string) bool {
+    for _, token := range m.Tokens {
+        if token == word {
+            return true
+        }
+    }
+    return false
+}
+
+// Value parses token i as a number, 0 if it is missing or not a number
+func (m *ScadaMessage) Value(i int) float64 {
+    if i >= len(m.Tokens) {
+        return 0.0
+    }
+    value, err := strconv.ParseFloat(m.Tokens[i], 64)
+    if err != nil {
+        return 0.0
+    }
+    return value
+}
+
+// BreakerState returns the open/close sequence of a breaker message, e.g. OPEN_CLOSE_OPEN for
+// OPEND-CLOSED-OPEND, UNKNOWN if the message has no state
+func (m *ScadaMessage) BreakerState() string {
+    if len(m.Tokens) < 5 {
+        return "UNKNOWN"
+    }
+    return strings.NewReplacer("D", "", "-", "_", "=", "_").Replace(m.State)
+}
+
+// Phases of the point name, as the devPh mappings in python/anomaly.py. Short points give "-".
+func firstPhase(m *ScadaMessage) string {
+    if m.Point == "-" || len(m.Point) < 1 {
+        return "-"
+    }
+    return m.Point[0:1]
+}
+
+func secondPhase(m *ScadaMessage) string {
+    if m.Point == "FAMP" || len(m.Point) < 2 {
+        return "-"
+    }
+    return m.Point[1:2]
+}
+
+// linePhase is the phase of L<phase> voltage points, the first character of others
+func linePhase(m *ScadaMessage) string {
+    if strings.HasPrefix(m.Point, "L") {
+        return secondPhase(m)
+    }
+    return firstPhase(m)
+}
+
+// lastPhase is the last character of 4-character switch points, e.g. DSWA
+func lastPhase(m *ScadaMessage) string {
+    if len(m.Point) != 4 {
+        return "-"
+    }
+    return m.Point[3:]
+}
+
+func noPhase(m *ScadaMessage) string {
+    return "-"
+}
+
+// ScadaAnomaly is an anomaly found in a SCADA message, with the value it was detected on ("-" if none)
+type ScadaAnomaly struct {
+    Anomaly string
+    Phase   string
+    Value   string
+}
+
+// scadaRule maps the messages of one family to anomalies. A message belongs to the family when it has all
+// tokens and substrings of contains, at least one of anyOf and none of excludes. The anomalies of messages
+// from a device type in variants are named <device type>_<anomaly>, e.g. FEEDER_CURRENT_LIMIT.
+type scadaRule struct {
+    family    string
+    tokens    []string
+    contains  []string
+    anyOf     []string
+    excludes  []string
+    phase     func(m *ScadaMessage) string
+    produces  []string
+    variants  []string
+    anomalies func(m *ScadaMessage) (names []string, value string)
+}
+
+func (r *scadaRule) matches(m *ScadaMessage) bool {
+    for _, token := range r.tokens {
+        if !m.HasToken(token) {
+            return false
+        }
+    }
+    for _, s := range r.contains {
+        if !strings.Contains(m.Text, s) {
+            return false
+        }
+    }
+    if len(r.anyOf) > 0 {
+        found := false
+        for _, s := range r.anyOf {
+            if strings.Contains(m.Text, s) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+    for _, s := range r.excludes {
+        if strings.Contains(m.Text, s) {
+            return false
+        }
+    }
+    return true
+}
+
+func always(name string) func(m *ScadaMessage) ([]string, string) {
+    return func(m *ScadaMessage) ([]string, string) {
+        return []string{name}, "-"
+    }
+}
+
+func formatScadaValue(value float64) string {
+    return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// scadaRules are the SCADA message families of python/anomaly.py. A message can belong to more than one.
+var scadaRules = []scadaRule{
+    {
+        family:   "breaker",
+        contains: []string{"FEED", "BKR"},
+        excludes: []string{"Composite", "STATUS", "DEFINITION", "CTRL", "OVERRIDDEN", "has experienced",
+            "Comments:", "ISD POINT", "operation"},
+        phase:    noPhase,
+        produces: []string{"BKR_OPEN", "BKR_CLOSE", "BKR_FAIL_TO_OPR"},
+        anomalies: func(m *ScadaMessage) ([]string, string) {
+            var names []string
+            state := m.BreakerState()
+            if strings.Contains(state, "OPEN") {
+                names = append(names, "BKR_OPEN")
+            }
+            if strings.Contains(state, "CLOSE") {
+                names = append(names, "BKR_CLOSE")
+            }
+            switch state {
+            case "OPEN_CLOSE_OPEN":
+                names = append(names, "BKR_OPEN")
+            case "CLOSE_OPEN_CLOSE":
+                names = append(names, "BKR_CLOSE")
+            case "FAIL_TO_OPR":
+                names = append(names, "BKR_FAIL_TO_OPR")
+            }
+            return names, "-"
+        },
+    },
+    {
+        family:    "fault alarm",
+        tokens:    []string{"FAULT"},
+        contains:  []string{" ALARM"},
+        excludes:  []string{" ANALOG ", " STATUS "},
+        phase:     firstPhase,
+        produces:  []string{"FAULT_ALARM"},
+        anomalies: always("FAULT_ALARM"),
+    },
+    {
+        family:   "fault current",
+        contains: []string{"LIM-HIGH"},
+        phase:    secondPhase,
+        produces: []string{"FAULT_CURRENT", "TEMP_FAULT_CURRENT"},
+        variants: []string{"INTELI"},
+        anomalies: func(m *ScadaMessage) ([]string, string) {
+            value := m.Value(5)
+            if value <= 1.0 {
+                return nil, "-"
+            } else if value < 900.0 {
+                return []string{"TEMP_FAULT_CURRENT"}, formatScadaValue(value)
+            }
+            return []string{"FAULT_CURRENT"}, formatScadaValue(value)
+        },
+    },
+    {
+        family:    "current limit",
+        contains:  []string{"AMP LIM-1 HIGH"},
+        phase:     firstPhase,
+        produces:  []string{"CURRENT_LIMIT"},
+        variants:  []string{"FDRHD", "FEEDER", "INTELI"},
+        anomalies: always("CURRENT_LIMIT"),
+    },
+    {
+        family:   "feeder head",
+        tokens:   []string{"FDRHD"},
+        anyOf:    []string{"ENGZ ENERGIZED", "ENGZ DE-ENERGIZED"},
+        phase:    noPhase,
+        produces: []string{"FDRHD_ENERGIZED", "FDRHD_DE_ENERGIZED"},
+        anomalies: func(m *ScadaMessage) ([]string, string) {
+            if strings.Contains(m.Text, "ENGZ ENERGIZED") {
+                return []string{"FDRHD_ENERGIZED"}, "-"
+            }
+            return []string{"FDRHD_DE_ENERGIZED"}, "-"
+        },
+    },
+    {
+        family:   "high voltage",
+        contains: []string{"HIGH"},
+        anyOf:    []string{"VLT LIM", "VT LIM"},
+        excludes: []string{" LOW ", "LIMIT"},
+        phase:    linePhase,
+        produces: []string{"HIGH_VOLTAGE"},
+        variants: []string{"FDRHD", "FEEDER", "INTELI"},
+        anomalies: func(m *ScadaMessage) ([]string, string) {
+            value := m.Value(6)
+            if value < 130.0 || value >= 1000.0 {
+                return nil, "-"
+            }
+            return []string{"HIGH_VOLTAGE"}, formatScadaValue(value)
+        },
+    },
+    {
+        family:    "INTELI phase alarm",
+        tokens:    []string{"INTELI"},
+        contains:  []string{"PH ALARM"},
+        phase:     firstPhase,
+        produces:  []string{"INTELI_PH_ALARM"},
+        anomalies: always("INTELI_PH_ALARM"),
+    },
+    {
+        family:   "INTELI switch",
+        tokens:   []string{"INTELI"},
+        contains: []string{"DSW"},
+        anyOf:    []string{"OPEN", "CLOSE"},
+        excludes: []string{"MAINT", "CTRL", "DEFINITION", "STATUS", "ABLED", "INHIBITED"},
+        phase:    lastPhase,
+        produces: []string{"INTELI_OPS_DSW_OPEN", "INTELI_OPS_DSW_CLOSE"},
+        anomalies: func(m *ScadaMessage) ([]string, string) {
+            if strings.Contains(m.Text, "OPEN") {
+                return []string{"INTELI_OPS_DSW_OPEN"}, "-"
+            }
+            return []string{"INTELI_OPS_DSW_CLOSE"}, "-"
+        },
+    },
+    {
+        family:    "regulator block",
+        tokens:    []string{"FDRHD", "REGU"},
+        contains:  []string{"BLOCK"},
+        excludes:  []string{" NORMAL", " STATUS ", " CTRL "},
+        phase:     noPhase,
+        produces:  []string{"REGULATOR_BLOCK"},
+        anomalies: always("REGULATOR_BLOCK"),
+    },
+    {
+        family:   "relay",
+        tokens:   []string{"RELAY"},
+        anyOf:    []string{"ALARM", "TRIP"},
+        excludes: []string{"NORMAL", "STATUS"},
+        phase:    noPhase,
+        produces: []string{"RELAY_ALARM", "RELAY_TRIP"},
+        anomalies: func(m *ScadaMessage) ([]string, string) {
+            var names []string
+            if strings.Contains(m.Text, "ALARM") {
+                names = append(names, "RELAY_ALARM")
+            }
+            if strings.Contains(m.Text, "TRIP") {
+                names = append(names, "RELAY_TRIP")
+            }
+            return names, "-"
+        },
+    },
+    {
+        family:    "voltage drop",
+        contains:  []string{"FORBDN"},
+        phase:     linePhase,
+        produces:  []string{"VOLTAGE_DROP"},
+        variants:  []string{"FDRHD", "FEEDER", "INTELI"},
+        anomalies: always("VOLTAGE_DROP"),
+    },
+}
+
+// ScadaAnomalies returns the anomalies of a message, in rule order
+func ScadaAnomalies(m *ScadaMessage) []ScadaAnomaly {
+    var anomalies []ScadaAnomaly
+    for i := range scadaRules {
+        rule := &scadaRules[i]
+        if !rule.matches(m) {
+            continue
+        }
+        prefix := ""
+        for _, deviceType := range rule.variants {
+            if m.DeviceType == deviceType {
+                prefix = deviceType + "_"
+            }
+        }
+        names, value := rule.anomalies(m)
+        for _, name := range names {
+            anomalies = append(anomalies, ScadaAnomaly{Anomaly: prefix + name, Phase: rule.phase(m), Value: value})
+        }
+    }
+    return anomalies
+}
+
+// ScadaAnomalyTypes returns the sorted names of the anomalies the SCADA rules produce, variants included
+func ScadaAnomalyTypes() []string {
+    var names []string
+    for _, rule := range scadaRules {
+        for _, name := range rule.produces {
+            names = append(names, name)
+            for _, deviceType := range rule.variants {
+                names = append(names, deviceType + "_" + name)
+            }
+        }
+    }
+    sort.Strings(names)
+    return names
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+    "path/filepath"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+type scadaCase struct {
+    text      string
+    anomalies []ScadaAnomaly
+}
+
+func checkScadaCases(t *testing.T, cases []scadaCase) {
+    for _, c := range cases {
+        got := ScadaAnomalies(ParseScadaMessage(c.text))
+        if !reflect.DeepEqual(got, c.anomalies) {
+            t.Errorf("%q: got %+v, want %+v", c.text, got, c.anomalies)
+        }
+    }
+}
+
+func TestParseScadaMessage(t *testing.T) {
+    m := ParseScadaMessage("STN FEEDER 806731 AAMP LIM-HIGH 950")
+    if m.DeviceType != "FEEDER" || m.DeviceID != "806731" || m.Point != "AAMP" || m.State != "LIM-HIGH" || m.Value(5) != 950.0 {
+        t.Errorf("got %+v", m)
+    }
+    m = ParseScadaMessage("STN")
+    if m.DeviceType != "-" || m.DeviceID != "-" || m.Point != "-" || m.State != "-" || m.Value(5) != 0.0 {
+        t.Errorf("short message: got %+v", m)
+    }
+    if got := ParseScadaMessage("STN FEEDER 806731 BKR OPEND-CLOSED-OPEND").BreakerState(); got != "OPEN_CLOSE_OPEN" {
+        t.Errorf("breaker state: got %s", got)
+    }
+}
+
+func TestScadaBreaker(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN FEEDER 806731 BKR OPEND", []ScadaAnomaly{{Anomaly: "BKR_OPEN", Phase: "-", Value: "-"}}},
+        {"STN FEEDER 806731 BKR CLOSED", []ScadaAnomaly{{Anomaly: "BKR_CLOSE", Phase: "-", Value: "-"}}},
+        {"STN FEEDER 806731 BKR OPEND-CLOSED-OPEND", []ScadaAnomaly{
+            {Anomaly: "BKR_OPEN", Phase: "-", Value: "-"},
+            {Anomaly: "BKR_CLOSE", Phase: "-", Value: "-"},
+            {Anomaly: "BKR_OPEN", Phase: "-", Value: "-"},
+        }},
+        {"STN FEEDER 806731 BKR FAIL-TO-OPR", []ScadaAnomaly{{Anomaly: "BKR_FAIL_TO_OPR", Phase: "-", Value: "-"}}},
+        {"STN FEEDER 806731 BKR", nil},
+        {"STN FEEDER 806731 BKR OPEND STATUS", nil},
+        {"STN FEEDER 806731 BKR CTRL OPEND", nil},
+    })
+}
+
+func TestScadaFaultAlarm(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN RELAY1 806731 AG FAULT ALARM", []ScadaAnomaly{{Anomaly: "FAULT_ALARM", Phase: "A", Value: "-"}}},
+        {"STN RELAY1 806731 AG FAULT ANALOG ALARM", nil},
+        {"STN RELAY1 806731 AG FAULT STATUS ALARM", nil},
+        {"STN X FAULT", nil},
+    })
+}
+
+func TestScadaFaultCurrent(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN FEEDER 806731 IAMP LIM-HIGH 950", []ScadaAnomaly{{Anomaly: "FAULT_CURRENT", Phase: "A", Value: "950"}}},
+        {"STN FEEDER 806731 IBMP LIM-HIGH 650.5", []ScadaAnomaly{{Anomaly: "TEMP_FAULT_CURRENT", Phase: "B", Value: "650.5"}}},
+        {"STN FEEDER 806731 FAMP LIM-HIGH 1200", []ScadaAnomaly{{Anomaly: "FAULT_CURRENT", Phase: "-", Value: "1200"}}},
+        {"STN FEEDER 806731 I LIM-HIGH 950", []ScadaAnomaly{{Anomaly: "FAULT_CURRENT", Phase: "-", Value: "950"}}},
+        {"STN INTELI 1234 IBMP LIM-HIGH 950", []ScadaAnomaly{{Anomaly: "INTELI_FAULT_CURRENT", Phase: "B", Value: "950"}}},
+        {"STN INTELI 1234 IBMP LIM-HIGH 650", []ScadaAnomaly{{Anomaly: "INTELI_TEMP_FAULT_CURRENT", Phase: "B", Value: "650"}}},
+        {"STN FEEDER 806731 IAMP LIM-HIGH", nil},
+        {"STN FEEDER 806731 IAMP LIM-HIGH n/a", nil},
+    })
+}
+
+func TestScadaCurrentLimit(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN FEEDER 806731 CAMP LIM-1 HIGH", []ScadaAnomaly{{Anomaly: "FEEDER_CURRENT_LIMIT", Phase: "C", Value: "-"}}},
+        {"STN RECL 1234 AAMP LIM-1 HIGH", []ScadaAnomaly{{Anomaly: "CURRENT_LIMIT", Phase: "A", Value: "-"}}},
+        {"STN FEEDER 806731 CAMP LIM-2 HIGH", nil},
+    })
+}
+
+func TestScadaFeederHead(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN FDRHD 806731 X ENGZ ENERGIZED", []ScadaAnomaly{{Anomaly: "FDRHD_ENERGIZED", Phase: "-", Value: "-"}}},
+        {"STN FDRHD 806731 X ENGZ DE-ENERGIZED", []ScadaAnomaly{{Anomaly: "FDRHD_DE_ENERGIZED", Phase: "-", Value: "-"}}},
+        {"STN FDRHD 806731 X ENGZ", nil},
+    })
+}
+
+func TestScadaHighVoltage(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN FEEDER 806731 LB VLT LIM 131.5 HIGH", []ScadaAnomaly{{Anomaly: "FEEDER_HIGH_VOLTAGE", Phase: "B", Value: "131.5"}}},
+        {"STN FDRHD 806731 C VT LIM 140 HIGH", []ScadaAnomaly{{Anomaly: "FDRHD_HIGH_VOLTAGE", Phase: "C", Value: "140"}}},
+        {"STN RECL 1234 C VT LIM 140 HIGH", []ScadaAnomaly{{Anomaly: "HIGH_VOLTAGE", Phase: "C", Value: "140"}}},
+        {"STN FEEDER 806731 C VT LIM 120 HIGH", nil},
+        {"STN FEEDER 806731 C VT LIM 1000 HIGH", nil},
+        {"STN FEEDER 806731 C VT LIM 140 LOW HIGH", nil},
+        {"STN FEEDER 806731 C VT LIMIT 140 HIGH", nil},
+    })
+}
+
+func TestScadaInteli(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN INTELI 1234 A PH ALARM", []ScadaAnomaly{{Anomaly: "INTELI_PH_ALARM", Phase: "A", Value: "-"}}},
+        {"STN INTELI 1234 DSWB OPEN", []ScadaAnomaly{{Anomaly: "INTELI_OPS_DSW_OPEN", Phase: "B", Value: "-"}}},
+        {"STN INTELI 1234 DSW CLOSE", []ScadaAnomaly{{Anomaly: "INTELI_OPS_DSW_CLOSE", Phase: "-", Value: "-"}}},
+        {"STN INTELI 1234 DSWB OPEN CTRL", nil},
+        {"STN INTELI 1234 DSWB ENABLED", nil},
+    })
+}
+
+func TestScadaRegulatorBlock(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN FDRHD 806731 REGU BLOCKED", []ScadaAnomaly{{Anomaly: "REGULATOR_BLOCK", Phase: "-", Value: "-"}}},
+        {"STN FDRHD 806731 REGU BLOCKED NORMAL", nil},
+        {"STN FDRHD 806731 REGU CTRL BLOCK", nil},
+    })
+}
+
+func TestScadaRelay(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN RELAY 806731 X ALARM", []ScadaAnomaly{{Anomaly: "RELAY_ALARM", Phase: "-", Value: "-"}}},
+        {"STN RELAY 806731 X TRIP", []ScadaAnomaly{{Anomaly: "RELAY_TRIP", Phase: "-", Value: "-"}}},
+        {"STN RELAY 806731 X TRIP NORMAL", nil},
+        {"STN RELAY 806731 X STATUS ALARM", nil},
+    })
+}
+
+func TestScadaVoltageDrop(t *testing.T) {
+    checkScadaCases(t, []scadaCase{
+        {"STN FEEDER 806731 LA FORBDN", []ScadaAnomaly{{Anomaly: "FEEDER_VOLTAGE_DROP", Phase: "A", Value: "-"}}},
+        {"STN INTELI 1234 B FORBDN", []ScadaAnomaly{{Anomaly: "INTELI_VOLTAGE_DROP", Phase: "B", Value: "-"}}},
+        {"STN RECL 1234 B FORBDN", []ScadaAnomaly{{Anomaly: "VOLTAGE_DROP", Phase: "B", Value: "-"}}},
+        {"FORBDN", []ScadaAnomaly{{Anomaly: "VOLTAGE_DROP", Phase: "-", Value: "-"}}},
+    })
+}
+
+// Anomalies of the SCADA set produced after the rules, by appendFcNoBo
+var scadaPostPassAnomalies = map[string]bool{"FC_NO_BO": true}
+
+// TestScadaAnomalyMaps checks that the SCADA rules produce the SCADA anomaly set, and every anomaly of the
+// shipped anomaly maps that no other source produces
+func TestScadaAnomalyMaps(t *testing.T) {
+    produced := make(map[string]bool)
+    for _, name := range ScadaAnomalyTypes() {
+        produced[name] = true
+    }
+    var set []string
+    for name := range produced {
+        set = append(set, name)
+    }
+    for name := range scadaPostPassAnomalies {
+        set = append(set, name)
+    }
+    sort.Strings(set)
+    all := append([]string{}, AnomalySets["scada"].All...)
+    sort.Strings(all)
+    if !reflect.DeepEqual(set, all) {
+        t.Errorf("SCADA rules produce %v, the SCADA anomaly set is %v", set, all)
+    }
+
+    other := make(map[string]bool)
+    for source, anomalySet := range AnomalySets {
+        if source != "scada" {
+            for _, name := range anomalySet.All {
+                other[name] = true
+            }
+        }
+    }
+    fileNames, err := filepath.Glob(filepath.Join("..", "data", "pam_*_anomaly_map.yaml"))
+    if err != nil || len(fileNames) == 0 {
+        t.Fatalf("no anomaly maps in ../data: %v", err)
+    }
+    for _, fileName := range fileNames {
+        anomalyMap, err := LoadAnomalyMap(fileName)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for name := range anomalyMap.Names {
+            if !other[name] && !produced[name] && !scadaPostPassAnomalies[name] {
+                t.Errorf("%s: %s is not produced by any source", fileName, name)
+            }
+        }
+    }
+}
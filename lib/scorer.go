@@ -0,0 +1,235 @@
+package lib
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Scorer predicts the probability of an outage from one signature row (XObject.Values, in dataset
+// column order). It replaces clf.predict_proba of the scikit-learn models used by python/alert.py.
+type Scorer interface {
+    Score(values []float64) (float64, error)
+}
+
+// A model exported to JSON. Type is "logistic" or "trees". Features names the model inputs in model
+// order; each must be a dataset column. Without Features the model takes the dataset columns in order.
+type scorerFile struct {
+    Type         string            `json:"type"`
+    Features     []string          `json:"features"`
+    Coefficients []float64         `json:"coefficients"` // logistic
+    Intercept    float64           `json:"intercept"`    // logistic
+    BaseScore    *float64          `json:"base_score"`   // trees: initial probability, 0.5 if missing
+    Objective    string            `json:"objective"`    // trees: binary:logistic (default) or reg:linear
+    Trees        []json.RawMessage `json:"trees"`        // trees: xgboost get_dump(dump_format="json")
+}
+
+// LoadScorer reads a logistic model or a tree ensemble from a JSON file, e.g.
+//
+//   {"type": "logistic", "features": ["BKR_CLOSE_24", ...], "coefficients": [0.3, ...], "intercept": -2.1}
+//   {"type": "trees", "features": ["BKR_CLOSE_24", ...], "base_score": 0.5, "trees": [<xgboost JSON dump>]}
+//
+// and binds its features to the dataset columns. LightGBM dumps are not supported.
+func LoadScorer(fileName string, dataset []DatasetObject) (Scorer, error) {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return nil, err
+    }
+    var model scorerFile
+    if err = json.Unmarshal(data, &model); err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+    columns, err := featureColumns(model.Features, dataset)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", fileName, err)
+    }
+
+    switch model.Type {
+    case "logistic":
+        if len(model.Coefficients) != len(columns) {
+            return nil, fmt.Errorf("%s: %d coefficients for %d features", fileName, len(model.Coefficients), len(columns))
+        }
+        return &LogisticScorer{Columns: columns, Coefficients: model.Coefficients, Intercept: model.Intercept}, nil
+    case "trees":
+        scorer := &TreeScorer{Columns: columns, Logistic: true}
+        switch model.Objective {
+        case "", "binary:logistic":
+        case "reg:linear", "reg:squarederror":
+            scorer.Logistic = false
+        default:
+            return nil, fmt.Errorf("%s: unsupported objective %q", fileName, model.Objective)
+        }
+        baseScore := 0.5
+        if model.BaseScore != nil {
+            baseScore = *model.BaseScore
+        }
+        if scorer.Logistic {
+            if baseScore <= 0 || baseScore >= 1 {
+                return nil, fmt.Errorf("%s: base_score %g is not a probability", fileName, baseScore)
+            }
+            scorer.BaseMargin = math.Log(baseScore / (1 - baseScore))
+        } else {
+            scorer.BaseMargin = baseScore
+        }
+        if len(model.Trees) == 0 {
+            return nil, fmt.Errorf("%s: no trees", fileName)
+        }
+        for i, raw := range model.Trees {
+            tree, err := parseTree(raw, model.Features, len(columns))
+            if err != nil {
+                return nil, fmt.Errorf("%s: tree %d: %v", fileName, i, err)
+            }
+            scorer.Trees = append(scorer.Trees, tree)
+        }
+        return scorer, nil
+    }
+    return nil, fmt.Errorf("%s: unknown model type %q (valid types: logistic, trees)", fileName, model.Type)
+}
+
+// featureColumns returns the dataset column index of every model feature
+func featureColumns(features []string, dataset []DatasetObject) ([]int, error) {
+    if len(features) == 0 {
+        columns := make([]int, len(dataset))
+        for i := range dataset {
+            columns[i] = i
+        }
+        return columns, nil
+    }
+    index := make(map[string]int)
+    for i, datasetObj := range dataset {
+        index[datasetObj.Name] = i
+    }
+    columns := make([]int, len(features))
+    for i, feature := range features {
+        column, ok := index[feature]
+        if !ok {
+            return nil, fmt.Errorf("feature %s is not a dataset column", feature)
+        }
+        columns[i] = column
+    }
+    return columns, nil
+}
+
+func sigmoid(x float64) float64 {
+    return 1 / (1 + math.Exp(-x))
+}
+
+// LogisticScorer is a fitted logistic regression: sigmoid(Intercept + Σ Coefficients[i] * x[Columns[i]])
+type LogisticScorer struct {
+    Columns      []int
+    Coefficients []float64
+    Intercept    float64
+}
+
+func (s *LogisticScorer) Score(values []float64) (float64, error) {
+    z := s.Intercept
+    for i, column := range s.Columns {
+        if column >= len(values) || math.IsNaN(values[column]) {
+            return 0, fmt.Errorf("feature %d has no value", i)
+        }
+        z += s.Coefficients[i] * values[column]
+    }
+    return sigmoid(z), nil
+}
+
+// TreeScorer is a gradient boosted tree ensemble: the leaf values of all trees are added to BaseMargin,
+// then mapped to a probability by the sigmoid when Logistic is set
+type TreeScorer struct {
+    Columns    []int
+    BaseMargin float64
+    Logistic   bool
+    Trees      []*TreeNode
+}
+
+// TreeNode is a split (Feature < Threshold goes to Yes, else No, NaN to Missing) or a leaf
+type TreeNode struct {
+    IsLeaf    bool
+    Leaf      float64
+    Feature   int // index into TreeScorer.Columns
+    Threshold float64
+    Yes       *TreeNode
+    No        *TreeNode
+    Missing   *TreeNode
+}
+
+func (s *TreeScorer) Score(values []float64) (float64, error) {
+    margin := s.BaseMargin
+    for _, tree := range s.Trees {
+        node := tree
+        for !node.IsLeaf {
+            column := s.Columns[node.Feature]
+            if column >= len(values) {
+                return 0, fmt.Errorf("feature %d has no value", node.Feature)
+            }
+            switch value := values[column]; {
+            case math.IsNaN(value):
+                node = node.Missing
+            case value < node.Threshold:
+                node = node.Yes
+            default:
+                node = node.No
+            }
+        }
+        margin += node.Leaf
+    }
+    if s.Logistic {
+        return sigmoid(margin), nil
+    }
+    return margin, nil
+}
+
+// One node of an xgboost JSON dump
+type dumpNode struct {
+    NodeId         int        `json:"nodeid"`
+    Leaf           *float64   `json:"leaf"`
+    Split          string     `json:"split"`
+    SplitCondition float64    `json:"split_condition"`
+    Yes            int        `json:"yes"`
+    No             int        `json:"no"`
+    Missing        int        `json:"missing"`
+    Children       []dumpNode `json:"children"`
+}
+
+// parseTree converts an xgboost dump. Splits name a feature ("BKR_CLOSE_24") or its position ("f3").
+func parseTree(raw json.RawMessage, features []string, numFeatures int) (*TreeNode, error) {
+    var root dumpNode
+    if err := json.Unmarshal(raw, &root); err != nil {
+        return nil, err
+    }
+    featureIndex := make(map[string]int)
+    for i, feature := range features {
+        featureIndex[feature] = i
+    }
+    return convertNode(&root, featureIndex, numFeatures)
+}
+
+func convertNode(node *dumpNode, featureIndex map[string]int, numFeatures int) (*TreeNode, error) {
+    if node.Leaf != nil {
+        return &TreeNode{IsLeaf: true, Leaf: *node.Leaf}, nil
+    }
+    feature, ok := featureIndex[node.Split]
+    if !ok {
+        index, err := strconv.Atoi(strings.TrimPrefix(node.Split, "f"))
+        if err != nil || !strings.HasPrefix(node.Split, "f") || index < 0 || index >= numFeatures {
+            return nil, fmt.Errorf("node %d: unknown feature %q", node.NodeId, node.Split)
+        }
+        feature = index
+    }
+    children := make(map[int]*TreeNode)
+    for i := range node.Children {
+        child, err := convertNode(&node.Children[i], featureIndex, numFeatures)
+        if err != nil {
+            return nil, err
+        }
+        children[node.Children[i].NodeId] = child
+    }
+    converted := &TreeNode{Feature: feature, Threshold: node.SplitCondition,
+        Yes: children[node.Yes], No: children[node.No], Missing: children[node.Missing]}
+    if converted.Yes == nil || converted.No == nil || converted.Missing == nil {
+        return nil, fmt.Errorf("node %d: missing child", node.NodeId)
+    }
+    return converted, nil
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+    "io/ioutil"
+    "math"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// The dataset columns the test models bind their features to, in an order different from the models'
+var scorerDataset = []DatasetObject{{Name: "C"}, {Name: "A"}, {Name: "B"}, {Name: "D"}}
+
+func loadTestScorer(t *testing.T, model string) (Scorer, error) {
+    dir, err := ioutil.TempDir("", "pam_scorer_")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    fileName := filepath.Join(dir, "model.json")
+    if err = ioutil.WriteFile(fileName, []byte(model), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return LoadScorer(fileName, scorerDataset)
+}
+
+func TestTreeScorer(t *testing.T) {
+    // xgboost get_dump(dump_format="json") of two trees: the first splits on A by name, then on C by
+    // position (f2), NaN going yes at the root and no below it; the second is a single leaf
+    model := `{"type": "trees", "features": ["A", "B", "C"], "base_score": 0.25, "trees": [
+        {"nodeid": 0, "depth": 0, "split": "A", "split_condition": 1.5, "yes": 1, "no": 2, "missing": 1, "children": [
+            {"nodeid": 1, "leaf": 0.4},
+            {"nodeid": 2, "depth": 1, "split": "f2", "split_condition": 10, "yes": 3, "no": 4, "missing": 4, "children": [
+                {"nodeid": 3, "leaf": -0.2},
+                {"nodeid": 4, "leaf": 0.7}
+            ]}
+        ]},
+        {"nodeid": 0, "leaf": 0.1}
+    ]}`
+    scorer, err := loadTestScorer(t, model)
+    if err != nil {
+        t.Fatal(err)
+    }
+    nan   := math.NaN()
+    tests := []struct {
+        values []float64 // C, A, B, D
+        want   float64   // sigmoid(log(0.25 / 0.75) + leaves)
+    }{
+        {[]float64{20, 1, 0, 0}, 0.35466124439244334},   // A yes: 0.4 + 0.1
+        {[]float64{20, 2, 0, 0}, 0.4258967557516616},    // A no, C no: 0.7 + 0.1
+        {[]float64{5, 2, 0, 0}, 0.2317221746177261},     // A no, C yes: -0.2 + 0.1
+        {[]float64{10, 1.5, 0, 0}, 0.4258967557516616},  // at the thresholds: no, no
+        {[]float64{5, nan, 0, 0}, 0.35466124439244334},  // A missing: yes
+        {[]float64{nan, 2, 0, 0}, 0.4258967557516616},   // C missing: no
+    }
+    for _, test := range tests {
+        got, err := scorer.Score(test.values)
+        if err != nil || math.Abs(got - test.want) > 1e-12 {
+            t.Errorf("%v: got %v (%v), want %v", test.values, got, err, test.want)
+        }
+    }
+
+    if _, err = loadTestScorer(t, `{"type": "trees", "features": ["A", "B", "C"], "trees": [
+        {"nodeid": 0, "split": "f3", "split_condition": 1, "yes": 1, "no": 2, "missing": 1, "children": [
+            {"nodeid": 1, "leaf": 0.4}, {"nodeid": 2, "leaf": 0.7}]}]}`); err == nil {
+        t.Errorf("split on f3 of 3 features: got no error")
+    }
+}
+
+func TestLogisticScorer(t *testing.T) {
+    scorer, err := loadTestScorer(t, `{"type": "logistic", "features": ["A", "B"], "coefficients": [0.5, -1.0], "intercept": -2.0}`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // sigmoid(-2 + 0.5 * 3 - 1 * 1)
+    if got, err := scorer.Score([]float64{100, 3, 1, 100}); err != nil || math.Abs(got - 0.18242552380635635) > 1e-12 {
+        t.Errorf("got %v (%v), want 0.18242552380635635", got, err)
+    }
+    if _, err = scorer.Score([]float64{100, math.NaN(), 1, 100}); err == nil {
+        t.Errorf("NaN feature: got no error")
+    }
+    if _, err = loadTestScorer(t, `{"type": "logistic", "features": ["A", "E"], "coefficients": [0.5, -1.0]}`); err == nil {
+        t.Errorf("feature E: got no error")
+    }
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "time"
+)
+
+const MonthFormat = "2006-01"
+
+// InputSelector selects input files by name, feeder and month. Empty fields select every file.
+type InputSelector struct {
+    Patterns  []string        // glob patterns (path.Match) of the file name, or of the whole path or S3 key if they contain a /
+    Feeders   map[string]bool // feeder IDs, matched against the 6-digit number in the file name
+    FromMonth string          // first month (MonthFormat) of the monthly directory or S3 prefix
+    ToMonth   string          // last month, inclusive
+}
+
+var (
+    fileFeederRegexp = regexp.MustCompile(`(?:^|[^0-9])([0-9]{6})(?:[^0-9]|$)`)
+    monthRegexp      = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{2})[-_]?(0[1-9]|1[0-2])(?:[^0-9]|$)`)
+)
+
+// fileFeeder returns the feeder ID in the name of an input file, e.g. 401636 for edna/response/401636.csv
+// or ami_401636.csv, "" if there is none
+func fileFeeder(name string) string {
+    matches := fileFeederRegexp.FindStringSubmatch(path.Base(filepath.ToSlash(name)))
+    if len(matches) == 0 {
+        return ""
+    }
+    return matches[1]
+}
+
+// directoryMonth returns the month (MonthFormat) in the name of a monthly directory or S3 prefix, e.g.
+// 2016-06 for 2016-06, 201606 or EDNA/2016_06, "" if there is none
+func directoryMonth(name string) string {
+    matches := monthRegexp.FindStringSubmatch(name)
+    if len(matches) == 0 {
+        return ""
+    }
+    return matches[1] + "-" + matches[2]
+}
+
+// Validate checks the patterns and months
+func (s *InputSelector) Validate() error {
+    for _, pattern := range s.Patterns {
+        if _, err := path.Match(pattern, ""); err != nil {
+            return fmt.Errorf("bad pattern %q: %v", pattern, err)
+        }
+    }
+    for _, month := range []string{s.FromMonth, s.ToMonth} {
+        if _, err := time.Parse(MonthFormat, month); month != "" && err != nil {
+            return fmt.Errorf("bad month %q (want YYYY-MM)", month)
+        }
+    }
+    if s.FromMonth != "" && s.ToMonth != "" && s.FromMonth > s.ToMonth {
+        return fmt.Errorf("month range %s to %s is empty", s.FromMonth, s.ToMonth)
+    }
+    return nil
+}
+
+// HasMonths reports whether the selector selects months, which only monthly inputs have
+func (s *InputSelector) HasMonths() bool {
+    return s.FromMonth != "" || s.ToMonth != ""
+}
+
+// selects reports whether the selector selects file
+func (s *InputSelector) selects(file inputFile) bool {
+    name := filepath.ToSlash(file.Tag)
+    if len(s.Patterns) > 0 {
+        matched := false
+        for _, pattern := range s.Patterns {
+            subject := path.Base(name)
+            if strings.Contains(pattern, "/") {
+                subject = name
+            }
+            if ok, _ := path.Match(pattern, subject); ok {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            return false
+        }
+    }
+    if len(s.Feeders) > 0 && !s.Feeders[fileFeeder(name)] {
+        return false
+    }
+    if s.HasMonths() {
+        if file.Month == "" || (s.FromMonth != "" && file.Month < s.FromMonth) || (s.ToMonth != "" && file.Month > s.ToMonth) {
+            return false
+        }
+    }
+    return true
+}
+
+// ReadFeederList reads feeder IDs from a file, one per line. Blank lines and lines starting with # are
+// skipped.
+func ReadFeederList(fileName string) ([]string, error) {
+    file, err := os.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var feeders []string
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line != "" && !strings.HasPrefix(line, "#") {
+            feeders = append(feeders, line)
+        }
+    }
+    return feeders, scanner.Err()
+}
@@ -0,0 +1,232 @@
+package lib
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+)
+
+const HOUR int64 = 3600
+
+// Special columns whose utility was found to be questionable. They are set to zero here and
+// overridden with every combination of their boolean values at prediction time.
+var deprecatedColumns = map[string]bool{
+    "FDR_GEO_0": true, "FDR_GEO_1": true, "FDR_GEO_2": true, "IS_DADE": true, "SIG_OUTLIER": true,
+}
+
+// Anomalies counted by the TRIPLE_THREAT rule: 3 or more of them at the same minute
+var tripleThreatAnomalies = []string{"PF_SPIKES", "THD_SPIKES", "ZERO_CURRENT", "ZERO_POWER", "ZERO_VOLTAGE"}
+
+// SignatureTransformer builds signatures from anomalies: one row per feeder and trigger time in
+// X (one value per dataset column, in column order) and the matching feeder/timestamp in Y.
+// Port of SignatureTransformer.transform in python/signature.py.
+type SignatureTransformer struct {
+    Dataset    []DatasetObject
+    AnomalyMap *AnomalyMap
+    FeederMap  map[string]Feeder
+    X          []XObject
+    Y          []YObject
+}
+
+func NewSignatureTransformer(dataset []DatasetObject, anomalyMap *AnomalyMap, feederMap map[string]Feeder) (*SignatureTransformer, error) {
+    for _, datasetObj := range dataset {
+        if strings.Contains(datasetObj.Name, "PH_FAULT") {
+            return nil, fmt.Errorf("column %s: PH_FAULT aggregation is not supported", datasetObj.Name)
+        }
+        if datasetObj.Type == "special" && !deprecatedColumns[datasetObj.Name] && !strings.Contains(datasetObj.Name, "TRIPLE_THREAT") {
+            return nil, fmt.Errorf("unknown special column %s", datasetObj.Name)
+        }
+    }
+    return &SignatureTransformer{Dataset: dataset, AnomalyMap: anomalyMap, FeederMap: feederMap}, nil
+}
+
+// Transform appends the signatures of every feeder in anomalies (map[FeederId]: [Anomaly1, ... Anomalyn])
+// to X and Y, feeders in sorted order. Feeders without metadata or ignored by Feeder.Ignored are skipped.
+func (s *SignatureTransformer) Transform(anomalies map[string][]Anomaly) {
+    var sortedFeederIds []string
+    for feederId := range anomalies {
+        sortedFeederIds = append(sortedFeederIds, feederId)
+    }
+    sort.Strings(sortedFeederIds)
+    for _, feederId := range sortedFeederIds {
+        feeder, ok := s.FeederMap[feederId]
+        if !ok || feeder.Ignored() {
+            continue
+        }
+        s.transformFeeder(feederId, feeder, s.cleanAnomalies(anomalies[feederId]))
+    }
+}
+
+// cleanAnomalies truncates times to the minute, renames anomalies with the anomaly map and returns the
+// sorted times of each renamed anomaly. Anomalies looked up by a keep_all: False column are deduplicated
+// per minute, those looked up by a keep_all: True column are all kept; a lookup used by both kinds of
+// column gets both sets, as in python/signature.py.
+func (s *SignatureTransformer) cleanAnomalies(fAnomalies []Anomaly) map[string][]int64 {
+    dropDupes := make(map[string]bool)
+    keepDupes := make(map[string]bool)
+    for _, datasetObj := range s.Dataset {
+        if datasetObj.IsAnomalyLookup() {
+            if datasetObj.KeepAll {
+                keepDupes[datasetObj.Lookup] = true
+            } else {
+                dropDupes[datasetObj.Lookup] = true
+            }
+        }
+    }
+
+    times := make(map[string][]int64)
+    seen  := make(map[string]map[int64]bool)
+    for _, anomaly := range fAnomalies {
+        name, ok := s.AnomalyMap.Lookup(anomaly.Anomaly)
+        if !ok {
+            continue
+        }
+        t := anomaly.EpochTime - anomaly.EpochTime % 60
+        if dropDupes[name] {
+            if _, ok := seen[name]; !ok {
+                seen[name] = make(map[int64]bool)
+            }
+            if !seen[name][t] {
+                seen[name][t] = true
+                times[name] = append(times[name], t)
+            }
+        }
+        if keepDupes[name] {
+            times[name] = append(times[name], t)
+        }
+    }
+    for name := range times {
+        sort.Sort(int64arr(times[name]))
+    }
+    return times
+}
+
+// transformFeeder creates a row for every unique time of a trigger anomaly
+func (s *SignatureTransformer) transformFeeder(feederId string, feeder Feeder, times map[string][]int64) {
+    var rowTimes int64arr
+    rowTimeMap := make(map[int64]bool)
+    for _, datasetObj := range s.Dataset {
+        if datasetObj.Type == "trigger" {
+            for _, t := range times[datasetObj.Lookup] {
+                if !rowTimeMap[t] {
+                    rowTimeMap[t] = true
+                    rowTimes = append(rowTimes, t)
+                }
+            }
+        }
+    }
+    sort.Sort(rowTimes)
+    tripleThreats := tripleThreatTimes(times)
+
+    for _, t := range rowTimes {
+        xObj := XObject{Values: make([]float64, len(s.Dataset))}
+        for i, datasetObj := range s.Dataset {
+            minLagTime := t - datasetObj.MinLag * HOUR
+            maxLagTime := t - datasetObj.MaxLag * HOUR
+            switch datasetObj.Type {
+            case "trigger", "background":
+                xObj.Values[i] = float64(len(windowTimes(times[datasetObj.Lookup], minLagTime, maxLagTime)))
+            case "cluster":
+                // number of groups of anomalies separated by more than an hour
+                window := windowTimes(times[datasetObj.Lookup], minLagTime, maxLagTime)
+                if len(window) > 0 {
+                    clusters := 1
+                    for k := 1; k < len(window); k++ {
+                        if window[k] - window[k-1] > HOUR {
+                            clusters++
+                        }
+                    }
+                    xObj.Values[i] = float64(clusters)
+                }
+            case "sequence":
+                // age of each anomaly in whole days
+                window := windowTimes(times[datasetObj.Lookup], minLagTime, maxLagTime)
+                if len(window) > 0 {
+                    days := make([]int64, len(window))
+                    for k, anomalyTime := range window {
+                        days[k] = (t - anomalyTime) / (24 * HOUR)
+                    }
+                    if xObj.Sequences == nil {
+                        xObj.Sequences = make(map[int][]int64)
+                    }
+                    xObj.Sequences[i] = days
+                    xObj.Values[i]    = float64(len(days))
+                }
+            case "flag":
+                if len(windowTimes(times[datasetObj.Lookup], minLagTime, maxLagTime)) > 0 {
+                    xObj.Values[i] = 1
+                }
+            case "special":
+                if strings.Contains(datasetObj.Name, "TRIPLE_THREAT") {
+                    xObj.Values[i] = float64(len(windowTimes(tripleThreats, minLagTime, maxLagTime)))
+                }
+            case "constant":
+                xObj.Values[i] = feeder.Constant(datasetObj.Name)
+            case "duration":
+                xObj.Values[i] = feeder.YearsSince(datasetObj.Name, t)
+            }
+        }
+        s.X = append(s.X, xObj)
+        s.Y = append(s.Y, YObject{Feeder: feederId, Timestamp: t, Outage: math.NaN()})
+    }
+}
+
+// AddTarget labels every row of Y with the hours until the PowerOff of a ticket of its feeder and
+// that ticket's key. Only tickets with PowerOff - maxLookahead < Timestamp < PowerOff + maxLookback
+// count; negative values are rows after the outage, within maxLookback. When several tickets
+// match, the smallest value is kept. Rows without a ticket keep Outage NaN and an empty Ticket.
+// Port of SignatureTransformer.add_target in python/signature.py.
+func (s *SignatureTransformer) AddTarget(tickets map[string][]Ticket, maxLookahead float64, maxLookback float64) {
+    for i := range s.Y {
+        yObj := &s.Y[i]
+        yObj.Outage = math.NaN()
+        yObj.Ticket = ""
+        for _, ticket := range tickets[yObj.Feeder] {
+            minTime := float64(ticket.PowerOffEpoch) - maxLookahead * float64(HOUR)
+            maxTime := float64(ticket.PowerOffEpoch) + maxLookback * float64(HOUR)
+            t := float64(yObj.Timestamp)
+            if t <= minTime || t >= maxTime {
+                continue
+            }
+            delta := float64(ticket.PowerOffEpoch - yObj.Timestamp) / float64(HOUR)
+            if yObj.Outage < delta { // false while Outage is NaN
+                continue
+            }
+            yObj.Outage = delta
+            yObj.Ticket = ticket.TicketKey
+        }
+    }
+}
+
+// windowTimes returns the sorted times t with maxLagTime < t <= minLagTime
+func windowTimes(times []int64, minLagTime int64, maxLagTime int64) []int64 {
+    lo := sort.Search(len(times), func(k int) bool { return times[k] > maxLagTime })
+    hi := sort.Search(len(times), func(k int) bool { return times[k] > minLagTime })
+    if lo >= hi {
+        return nil
+    }
+    return times[lo:hi]
+}
+
+// tripleThreatTimes returns the sorted minutes at which 3 or more distinct triple threat anomalies occur
+func tripleThreatTimes(times map[string][]int64) []int64 {
+    counts := make(map[int64]int)
+    for _, name := range tripleThreatAnomalies {
+        var prev int64 = -1
+        for k, t := range times[name] {
+            if k == 0 || t != prev {
+                counts[t]++
+            }
+            prev = t
+        }
+    }
+    var threats int64arr
+    for t, count := range counts {
+        if count > 2 {
+            threats = append(threats, t)
+        }
+    }
+    sort.Sort(threats)
+    return threats
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+    "encoding/csv"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestSignatureTransform(t *testing.T) {
+    dataset := []DatasetObject{
+        {Name: "TRIG", Lookup: "T", Type: "trigger", MinLag: 0, MaxLag: 24},
+        {Name: "BG", Lookup: "B", Type: "background", MinLag: 0, MaxLag: 24},
+        {Name: "CLU", Lookup: "B", Type: "cluster", MinLag: 0, MaxLag: 24},
+        {Name: "SEQ", Lookup: "B", Type: "sequence", MinLag: 0, MaxLag: 240},
+        {Name: "SEQ_EMPTY", Lookup: "C", Type: "sequence", MinLag: 0, MaxLag: 24},
+        {Name: "FLAG", Lookup: "B", Type: "flag", MinLag: 0, MaxLag: 24},
+        {Name: "PF", Lookup: "PF_SPIKES", Type: "background", MinLag: 0, MaxLag: 24},
+        {Name: "THD", Lookup: "THD_SPIKES", Type: "background", MinLag: 0, MaxLag: 24},
+        {Name: "ZC", Lookup: "ZERO_CURRENT", Type: "background", MinLag: 0, MaxLag: 24},
+        {Name: "TRIPLE_THREAT", Type: "special", MinLag: 0, MaxLag: 24},
+        {Name: "IS_DADE", Type: "special"},
+        {Name: "AFS", Type: "constant"},
+        {Name: "HARDENING", Type: "duration"},
+    }
+    anomalyMap := &AnomalyMap{Names: map[string]string{
+        "RAW_T": "T", "RAW_B": "B", "RAW_C": "C",
+        "PF_SPIKES": "PF_SPIKES", "THD_SPIKES": "THD_SPIKES", "ZERO_CURRENT": "ZERO_CURRENT",
+    }}
+    feederMap := map[string]Feeder{
+        "123456": {FeederId: "123456", Customers: 1000, FdrOh: 1,
+            Metadata: map[string]string{"AFS": "True", "HARDENING": "2006-01-01 00:00:00+00:00"}},
+        "999999": {FeederId: "999999", Customers: 10, FdrOh: 1},
+    }
+
+    T := time.Date(2016, 1, 10, 12, 0, 0, 0, time.UTC).Unix()
+    anomaly := func(name string, epochTime int64) Anomaly {
+        return Anomaly{Anomaly: name, FeederId: "123456", EpochTime: epochTime}
+    }
+    anomalies := map[string][]Anomaly{
+        "123456": {
+            anomaly("RAW_T", T + 30),          // truncated to the minute
+            anomaly("RAW_T", T - 48 * HOUR),
+            anomaly("RAW_B", T - HOUR),
+            anomaly("RAW_B", T - HOUR + 10),   // same minute, dropped
+            anomaly("RAW_B", T - 3 * HOUR),
+            anomaly("RAW_B", T - 5 * 24 * HOUR),
+            anomaly("RAW_B", T + HOUR),        // after the last row
+            anomaly("UNMAPPED", T - HOUR),
+            anomaly("PF_SPIKES", T - 2 * HOUR),
+            anomaly("THD_SPIKES", T - 2 * HOUR),
+            anomaly("ZERO_CURRENT", T - 2 * HOUR),
+            anomaly("PF_SPIKES", T - 4 * HOUR),
+            anomaly("THD_SPIKES", T - 4 * HOUR),
+        },
+        "999999": {anomaly("RAW_T", T)},
+    }
+
+    s, err := NewSignatureTransformer(dataset, anomalyMap, feederMap)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s.Transform(anomalies)
+
+    dir, err := ioutil.TempDir("", "pam_signature_")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    fileName := filepath.Join(dir, "signature.csv")
+    if err = s.WriteCSV(fileName); err != nil {
+        t.Fatal(err)
+    }
+    file, err := os.Open(fileName)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    records, err := csv.NewReader(file).ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // FEEDER, TIMESTAMP, OUTAGE, TICKET, then the dataset columns in order
+    want := [][]string{
+        {"123456", "2016-01-08 12:00:00", "", "",
+            "1", "0", "0", "[3]", "0", "0", "0", "0", "0", "0", "0", "1", "10"},
+        {"123456", "2016-01-10 12:00:00", "", "",
+            "1", "2", "2", "[5, 0, 0]", "0", "1", "2", "2", "1", "1", "0", "1", "10"},
+    }
+    if len(records) == 0 || len(records[0]) != len(signatureYColumns) + len(dataset) {
+        t.Fatalf("header: got %v", records)
+    }
+    if !reflect.DeepEqual(records[1:], want) {
+        t.Errorf("got %q, want %q", records[1:], want)
+    }
+}
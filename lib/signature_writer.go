@@ -0,0 +1,207 @@
+package lib
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/xitongsys/parquet-go/writer"
+)
+
+// Time format of TIMESTAMP in signature and prediction files, UTC
+const SignatureTimeFormat = "2006-01-02 15:04:05"
+
+// Columns of y, written before the dataset columns of X: row keys then targets
+var signatureYColumns = []string{"FEEDER", "TIMESTAMP", "OUTAGE", "TICKET"}
+var signatureYTypes   = []string{"key", "key", "target", "target"}
+
+// SignatureSchema is the JSON sidecar written next to the signature files
+type SignatureSchema struct {
+    DatasetVersion    string            `json:"dataset_version"`
+    AnomalyMapVersion string            `json:"anomaly_map_version"`
+    StartTime         string            `json:"start_time"` // first and last TIMESTAMP, UTC
+    EndTime           string            `json:"end_time"`
+    Feeders           int               `json:"feeders"`
+    Rows              int               `json:"rows"`
+    Columns           []SignatureColumn `json:"columns"`
+}
+
+type SignatureColumn struct {
+    Name   string `json:"name"`
+    Type   string `json:"type"`             // key, target or a dataset column type
+    Lookup string `json:"lookup,omitempty"` // anomaly lookup columns only
+    MinLag *int64 `json:"min_lag,omitempty"`
+    MaxLag *int64 `json:"max_lag,omitempty"`
+}
+
+// Schema describes the signatures in X and Y, columns in file order
+func (s *SignatureTransformer) Schema(datasetVersion string) SignatureSchema {
+    schema := SignatureSchema{DatasetVersion: datasetVersion, Rows: len(s.Y)}
+    if s.AnomalyMap != nil {
+        schema.AnomalyMapVersion = s.AnomalyMap.Version
+    }
+    for i, name := range signatureYColumns {
+        schema.Columns = append(schema.Columns, SignatureColumn{Name: name, Type: signatureYTypes[i]})
+    }
+    for _, datasetObj := range s.Dataset {
+        column := SignatureColumn{Name: datasetObj.Name, Type: datasetObj.Type}
+        if datasetObj.IsAnomalyLookup() {
+            minLag, maxLag := datasetObj.MinLag, datasetObj.MaxLag
+            column.Lookup, column.MinLag, column.MaxLag = datasetObj.Lookup, &minLag, &maxLag
+        }
+        schema.Columns = append(schema.Columns, column)
+    }
+
+    feeders := make(map[string]bool)
+    var startTime, endTime int64
+    for i, yObj := range s.Y {
+        feeders[yObj.Feeder] = true
+        if i == 0 || yObj.Timestamp < startTime {
+            startTime = yObj.Timestamp
+        }
+        if i == 0 || yObj.Timestamp > endTime {
+            endTime = yObj.Timestamp
+        }
+    }
+    if len(s.Y) > 0 {
+        schema.StartTime = formatSignatureTime(startTime)
+        schema.EndTime   = formatSignatureTime(endTime)
+    }
+    schema.Feeders = len(feeders)
+    return schema
+}
+
+// WriteCSV writes one line per signature: FEEDER, TIMESTAMP, OUTAGE, TICKET and then the dataset columns.
+// NaN values are left empty; sequence columns hold their day offsets, e.g. "[0, 3]", or 0 when the window
+// has no anomalies, as in python/signature.py.
+func (s *SignatureTransformer) WriteCSV(fileName string) error {
+    file, err := createOutputFile(fileName)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    w      := csv.NewWriter(file)
+    header := append([]string{}, signatureYColumns...)
+    for _, datasetObj := range s.Dataset {
+        header = append(header, datasetObj.Name)
+    }
+    if err = w.Write(header); err != nil {
+        return err
+    }
+    for i, yObj := range s.Y {
+        record := []string{yObj.Feeder, formatSignatureTime(yObj.Timestamp), formatSignatureValue(yObj.Outage), yObj.Ticket}
+        for j, datasetObj := range s.Dataset {
+            if datasetObj.Type == "sequence" {
+                record = append(record, formatSequence(s.X[i].Sequences[j]))
+            } else {
+                record = append(record, formatSignatureValue(s.X[i].Values[j]))
+            }
+        }
+        if err = w.Write(record); err != nil {
+            return err
+        }
+    }
+    w.Flush()
+    if err = w.Error(); err != nil {
+        return err
+    }
+    return file.Close()
+}
+
+// WriteParquet writes the same table as WriteCSV. TIMESTAMP is stored as TIMESTAMP_MILLIS, NaN values as null.
+func (s *SignatureTransformer) WriteParquet(fileName string) error {
+    file, err := createOutputFile(fileName)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    md := []string{
+        "name=FEEDER, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED",
+        "name=TIMESTAMP, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=REQUIRED",
+        "name=OUTAGE, type=DOUBLE, repetitiontype=OPTIONAL",
+        "name=TICKET, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL",
+    }
+    for _, datasetObj := range s.Dataset {
+        if datasetObj.Type == "sequence" {
+            md = append(md, fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED", datasetObj.Name))
+        } else {
+            md = append(md, fmt.Sprintf("name=%s, type=DOUBLE, repetitiontype=OPTIONAL", datasetObj.Name))
+        }
+    }
+    pw, err := writer.NewCSVWriterFromWriter(md, file, 1)
+    if err != nil {
+        return err
+    }
+    for i, yObj := range s.Y {
+        record := []interface{}{yObj.Feeder, yObj.Timestamp * 1000, parquetValue(yObj.Outage), nil}
+        if yObj.Ticket != "" {
+            record[3] = yObj.Ticket
+        }
+        for j, datasetObj := range s.Dataset {
+            if datasetObj.Type == "sequence" {
+                record = append(record, formatSequence(s.X[i].Sequences[j]))
+            } else {
+                record = append(record, parquetValue(s.X[i].Values[j]))
+            }
+        }
+        if err = pw.Write(record); err != nil {
+            return err
+        }
+    }
+    if err = pw.WriteStop(); err != nil {
+        return err
+    }
+    return file.Close()
+}
+
+// WriteSchema writes the JSON sidecar returned by Schema
+func (s *SignatureTransformer) WriteSchema(fileName string, datasetVersion string) error {
+    data, err := json.MarshalIndent(s.Schema(datasetVersion), "", "  ")
+    if err != nil {
+        return err
+    }
+    file, err := createOutputFile(fileName)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    if _, err = file.Write(append(data, '\n')); err != nil {
+        return err
+    }
+    return file.Close()
+}
+
+func formatSignatureTime(epochTime int64) string {
+    return time.Unix(epochTime, 0).UTC().Format(SignatureTimeFormat)
+}
+
+func formatSignatureValue(value float64) string {
+    if math.IsNaN(value) {
+        return ""
+    }
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func formatSequence(days []int64) string {
+    if len(days) == 0 {
+        return "0"
+    }
+    values := make([]string, len(days))
+    for i, day := range days {
+        values[i] = strconv.FormatInt(day, 10)
+    }
+    return "[" + strings.Join(values, ", ") + "]"
+}
+
+func parquetValue(value float64) interface{} {
+    if math.IsNaN(value) {
+        return nil
+    }
+    return value
+}
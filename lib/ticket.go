@@ -1,10 +1,9 @@
 package lib
 
 import (
-    "bufio"
     "fmt"
+    "io"
     "io/ioutil"
-    "log"
     "os"
     "sort"
     "strings"
@@ -40,92 +39,164 @@ type Ticket struct {
     PowerOffEpoch           int64
 }
 
-func (t *Ticket) Create(ticketLine string) {
+// Create reads a ticket row. A POWEROFF that is missing or not a time, or a POWERRESTORE or
+// REPAIRACTIONCREATETIME that is not a time (they may be missing), is an error. Times are local times in
+// zone.
+func (t *Ticket) Create(record []string, header CSVHeader, zone *TimeZone) error {
     longForm        := "01-02-2006 15:04:05"
-    lineComponents  := strings.Split(ticketLine, ",")
-    t.TicketKey                 = strings.Replace(lineComponents[0], "\"", "", -1)
-    t.FeederNumber              = strings.Replace(lineComponents[1], "\"", "", -1)
-    t.TroubleTicketNumber       = strings.Replace(lineComponents[2], "\"", "", -1)
-    t.GrnTicketFlag             = strings.Replace(lineComponents[3], "\"", "", -1)
-    t.IrptTypeCode              = strings.Replace(lineComponents[4], "\"", "", -1)
-    t.TicketTypeCode            = strings.Replace(lineComponents[5], "\"", "", -1)
-    t.SuptCode                  = strings.Replace(lineComponents[6], "\"", "", -1)
-    t.IrptCauseCode             = strings.Replace(lineComponents[7], "\"", "", -1)
-    t.EquipmentCode             = strings.Replace(lineComponents[8], "\"", "", -1)
-    t.CMI                       = strings.Replace(lineComponents[9], "\"", "", -1)
-    t.PowerOff, _               = time.Parse(longForm, strings.Replace(lineComponents[10], "\"", "", -1))
-    t.PowerRestore, _           = time.Parse(longForm, strings.Replace(lineComponents[11], "\"", "", -1))
-    t.RprActionType             = strings.Replace(lineComponents[12], "\"", "", -1)
-    t.RprActionSubtype          = strings.Replace(lineComponents[13], "\"", "", -1)
-    t.RprActionDs               = strings.Replace(lineComponents[14], "\"", "", -1)
-    t.APhaseInvolved            = strings.Replace(lineComponents[15], "\"", "", -1)
-    t.BPhaseInvolved            = strings.Replace(lineComponents[16], "\"", "", -1)
-    t.CPhaseInvolved            = strings.Replace(lineComponents[17], "\"", "", -1)
-    t.TicketDvcCoor             = strings.Replace(lineComponents[18], "\"", "", -1)
-    t.RepairActionCreateTime, _ = time.Parse(longForm, strings.Replace(lineComponents[19], "\"", "", -1))
-    t.RepairActionStatePlaneX   = strings.Replace(lineComponents[20], "\"", "", -1)
-    t.RepairActionStatePlaneY   = strings.Replace(lineComponents[21], "\"", "", -1)
-    t.CurrentRowFlag            = strings.Replace(lineComponents[22], "\"", "", -1)
+    var err error
+    t.TicketKey                 = header.Get(record, "DW_TCKT_KEY")
+    t.FeederNumber              = header.Get(record, "FDR_NUM")
+    t.TroubleTicketNumber       = header.Get(record, "TRBL_TCKT_NUM")
+    t.GrnTicketFlag             = header.Get(record, "GRN_TCKT_FLAG")
+    t.IrptTypeCode              = header.Get(record, "IRPT_TYPE_CODE")
+    t.TicketTypeCode            = header.Get(record, "TCKT_TYPE_CODE")
+    t.SuptCode                  = header.Get(record, "SUPT_CODE")
+    t.IrptCauseCode             = header.Get(record, "IRPT_CAUS_CODE")
+    t.EquipmentCode             = header.Get(record, "EQP_CODE")
+    t.CMI                       = header.Get(record, "CMI")
+    if t.PowerOff, err = parseTimeField("POWEROFF", longForm, header.Get(record, "POWEROFF"), zone, true); err != nil {
+        return err
+    }
+    if t.PowerRestore, err = parseTimeField("POWERRESTORE", longForm, header.Get(record, "POWERRESTORE"), zone, false); err != nil {
+        return err
+    }
+    t.RprActionType             = header.Get(record, "RPR_ACTN_TYPE")
+    t.RprActionSubtype          = header.Get(record, "RPR_ACTN_SUB_TYPE")
+    t.RprActionDs               = header.Get(record, "RPR_ACTN_DS")
+    t.APhaseInvolved            = header.Get(record, "A_PHAS_INVOLVED")
+    t.BPhaseInvolved            = header.Get(record, "B_PHAS_INVOLVED")
+    t.CPhaseInvolved            = header.Get(record, "C_PHAS_INVOLVED")
+    t.TicketDvcCoor             = header.Get(record, "TCKT_DVC_COOR")
+    t.RepairActionCreateTime, err = parseTimeField("REPAIRACTIONCREATETIME", longForm,
+        header.Get(record, "REPAIRACTIONCREATETIME"), zone, false)
+    if err != nil {
+        return err
+    }
+    t.RepairActionStatePlaneX   = header.Get(record, "REPAIREDACTIONSTATEPLANEX")
+    t.RepairActionStatePlaneY   = header.Get(record, "REPAIREDACTIONSTATEPLANEY")
+    t.CurrentRowFlag            = header.Get(record, "CRNT_ROW_FLAG")
 
     t.PowerOffEpoch             = t.PowerOff.Unix()
+    return nil
 }
 
-func GetTicketMap(dirName string) map[string][]Ticket {
-    var tmpMap map[string][]Ticket = make(map[string][]Ticket)
+// ticketRow is a ticket row with the file and line it was read from
+type ticketRow struct {
+    ticket Ticket
+    source TicketReject
+}
+
+// GetTicketMap reads the TICKETS .csv files of dirName and returns the tickets the filter selects by
+// feeder, sorted by POWEROFF, one row per ticket (picked by the dedup rule), with a report of the rows
+// it rejected and why. Times are read in zone. Rows that cannot be read are rejected as bad rows (in
+// report.BadRows); a file with more than maxBadRows of them (< 0 for no limit) is an error.
+func GetTicketMap(dirName string, filter *TicketFilter, zone *TimeZone, maxBadRows int) (map[string][]Ticket, *TicketReport, error) {
+    var tmpMap map[string][]ticketRow = make(map[string][]ticketRow)
     var ticketMap map[string][]Ticket = make(map[string][]Ticket)
-    files, _  := ioutil.ReadDir(dirName)
-    for _, f  := range files {
+    report     := new(TicketReport)
+    files, err := ioutil.ReadDir(dirName)
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, f := range files {
         filePath := dirName + "/" + f.Name()
-        if strings.Contains(f.Name(), "TICKETS") && strings.Contains(f.Name(), ".csv") {
-            fmt.Printf("Doing %s\n", f.Name())
-            if file, err := os.Open(filePath); err == nil {
-                defer file.Close()
-                scanner   := bufio.NewScanner(file)
-                lineCount := 0
-                for scanner.Scan() {
-                    line := scanner.Text()
-                    if lineCount > 0 {
-                        if len(strings.Split(line, ",")) >= 5 {
-                            ticket := new(Ticket)
-                            ticket.Create(line)
-                            // only use tickets with IrptCauseCode in {188, 189}
-                            if (ticket.IrptCauseCode == "188" || ticket.IrptCauseCode == "189") &&
-                                (ticket.IrptTypeCode == "FDR" || ticket.IrptTypeCode == "OCR") {
-                                // ticketMap[ticket.FeederNumber] = append(ticketMap[ticket.FeederNumber], *ticket)
-                                if _, ok := tmpMap[ticket.TicketKey]; !ok {
-                                    tmpMap[ticket.TicketKey] = make([]Ticket, 0)
-                                }
-                                tmpMap[ticket.TicketKey] = append(tmpMap[ticket.TicketKey], *ticket)
-                            }
-                        }
-                    }
-                    lineCount++
-                }
-                if err = scanner.Err(); err != nil {
-                    log.Fatal(err)
-                }
-            } else {
-                log.Fatal(err)
-            }
+        if !strings.Contains(f.Name(), "TICKETS") || !strings.Contains(f.Name(), ".csv") {
+            continue
+        }
+        fmt.Printf("Doing %s\n", f.Name())
+        bad := NewBadRows(maxBadRows)
+        if err := readTicketRows(filePath, filter, zone, report, bad, tmpMap); err != nil {
+            return nil, nil, err
         }
+        report.BadRows = append(report.BadRows, bad.Rows...)
     }
 
-    // cycle through tmpMap and construct ticketMap
-    for k, _ := range tmpMap {
-        sort.Slice(tmpMap[k], func(i, j int) bool {
-            return tmpMap[k][i].PowerOffEpoch < tmpMap[k][j].PowerOffEpoch
+    // cycle through tmpMap and construct ticketMap, one row per ticket
+    keys := make([]string, 0, len(tmpMap))
+    for k := range tmpMap {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        rows := tmpMap[k]
+        sort.SliceStable(rows, func(i, j int) bool {
+            return rows[i].ticket.PowerOffEpoch < rows[j].ticket.PowerOffEpoch
         })
-        firstTicket := tmpMap[k][0]
-        if _, ok := ticketMap[firstTicket.FeederNumber]; !ok {
-            ticketMap[firstTicket.FeederNumber] = make([]Ticket, 0)
+        tickets := make([]Ticket, len(rows))
+        for i := range rows {
+            tickets[i] = rows[i].ticket
+        }
+        picked := filter.pick(tickets)
+        for i, row := range rows {
+            if i == picked {
+                continue
+            }
+            row.source.Reason = rejectDuplicate
+            if picked < 0 {
+                row.source.Reason = rejectNoCurrentRow
+            }
+            report.add(row.source)
         }
-        ticketMap[firstTicket.FeederNumber] = append(ticketMap[firstTicket.FeederNumber], firstTicket)
+        if picked < 0 {
+            continue
+        }
+        ticket := tickets[picked]
+        ticketMap[ticket.FeederNumber] = append(ticketMap[ticket.FeederNumber], ticket)
+        report.Tickets++
     }
     // sort all ticket arrays (by feeder) in ticketMap
     for k, _ := range ticketMap {
-        sort.Slice(ticketMap[k], func(i, j int) bool {
+        sort.SliceStable(ticketMap[k], func(i, j int) bool {
             return ticketMap[k][i].PowerOffEpoch < ticketMap[k][j].PowerOffEpoch
         })
     }
-    return ticketMap
+    return ticketMap, report, nil
+}
+
+// readTicketRows adds the rows of a tickets file the filter keeps to tmpMap, by ticket key
+func readTicketRows(filePath string, filter *TicketFilter, zone *TimeZone, report *TicketReport, bad *BadRows,
+    tmpMap map[string][]ticketRow) error {
+    file, err := os.Open(filePath)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    reader      := newCSVReader(file)
+    header, err := readCSVHeader(filePath, reader, "DW_TCKT_KEY", "FDR_NUM", "GRN_TCKT_FLAG",
+        "IRPT_TYPE_CODE", "IRPT_CAUS_CODE", "CMI", "POWEROFF", "CRNT_ROW_FLAG")
+    if err != nil {
+        return err
+    }
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            return nil
+        } else if err != nil {
+            return fmt.Errorf("%s: %v", filePath, err)
+        }
+        report.Rows++
+        line, _ := reader.FieldPos(0)
+        source  := TicketReject{File: filePath, Line: line}
+        ticket  := new(Ticket)
+        if len(record) < header.Width {
+            err = shortRowError(record, header)
+        } else {
+            err = ticket.Create(record, header, zone)
+        }
+        if err != nil {
+            source.Reason = rejectBadRow
+            report.add(source)
+            if err = bad.Add(readRowError(filePath, reader, record, err)); err != nil {
+                return err
+            }
+            continue
+        }
+        source.TicketKey = ticket.TicketKey
+        if source.Reason = filter.reject(ticket); source.Reason != "" {
+            report.add(source)
+        } else {
+            tmpMap[ticket.TicketKey] = append(tmpMap[ticket.TicketKey], ticketRow{*ticket, source})
+        }
+    }
 }
@@ -0,0 +1,201 @@
+package lib
+
+import (
+    "encoding/csv"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Dedup rules of TicketConfig: which row of a ticket labels signatures
+const (
+    TicketDedupEarliest = "earliest" // the row with the earliest POWEROFF
+    TicketDedupLatest   = "latest"   // the row with the latest POWEROFF
+    TicketDedupCurrent  = "current"  // the row with CRNT_ROW_FLAG=Y (the earliest if there are several)
+)
+
+// Reasons for rejecting a ticket row
+const (
+    rejectBadRow       = "bad row"
+    rejectCauseCode    = "cause code"
+    rejectTypeCode     = "type code"
+    rejectGreenTicket  = "green ticket flag"
+    rejectCMI          = "CMI"
+    rejectDateRange    = "date range"
+    rejectDuplicate    = "duplicate"
+    rejectNoCurrentRow = "no current row"
+)
+
+// TicketConfig selects the tickets that label signatures. Code lists are comma-separated; empty values
+// select every ticket. Dates are days (YYYY-MM-DD) of the POWEROFF in time_zones.tickets, both
+// inclusive.
+type TicketConfig struct {
+    CauseCodes  string `yaml:"cause_codes"  json:"cause_codes"`  // IRPT_CAUS_CODE
+    TypeCodes   string `yaml:"type_codes"   json:"type_codes"`   // IRPT_TYPE_CODE
+    GreenTicket string `yaml:"green_ticket" json:"green_ticket"` // GRN_TCKT_FLAG, Y or N
+    MinCMI      string `yaml:"min_cmi"      json:"min_cmi"`
+    FromDate    string `yaml:"from_date"    json:"from_date"`
+    ToDate      string `yaml:"to_date"      json:"to_date"`
+    Dedup       string `yaml:"dedup"        json:"dedup"`        // earliest, latest or current
+}
+
+// TicketFilter is a parsed TicketConfig
+type TicketFilter struct {
+    CauseCodes  map[string]bool
+    TypeCodes   map[string]bool
+    GreenTicket string
+    MinCMI      float64
+    HasMinCMI   bool
+    From        time.Time
+    To          time.Time // the end of the last day, exclusive
+    Dedup       string
+}
+
+// Filter parses the ticket selection, with days starting at midnight in location
+func (t *TicketConfig) Filter(location *time.Location) (*TicketFilter, error) {
+    f := &TicketFilter{CauseCodes: codeSet(t.CauseCodes), TypeCodes: codeSet(t.TypeCodes),
+        GreenTicket: strings.TrimSpace(t.GreenTicket), Dedup: t.Dedup}
+    if f.GreenTicket != "" && f.GreenTicket != "Y" && f.GreenTicket != "N" {
+        return nil, fmt.Errorf("tickets.green_ticket: %q is not Y or N", t.GreenTicket)
+    }
+    if t.MinCMI != "" {
+        minCMI, err := strconv.ParseFloat(t.MinCMI, 64)
+        if err != nil {
+            return nil, fmt.Errorf("tickets.min_cmi: %q is not a number", t.MinCMI)
+        }
+        f.MinCMI, f.HasMinCMI = minCMI, true
+    }
+    var err error
+    if t.FromDate != "" {
+        if f.From, err = time.ParseInLocation("2006-01-02", t.FromDate, location); err != nil {
+            return nil, fmt.Errorf("tickets.from_date: %q is not a YYYY-MM-DD day", t.FromDate)
+        }
+    }
+    if t.ToDate != "" {
+        if f.To, err = time.ParseInLocation("2006-01-02", t.ToDate, location); err != nil {
+            return nil, fmt.Errorf("tickets.to_date: %q is not a YYYY-MM-DD day", t.ToDate)
+        }
+        f.To = f.To.AddDate(0, 0, 1)
+    }
+    if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+        return nil, fmt.Errorf("tickets.from_date %s is after tickets.to_date %s", t.FromDate, t.ToDate)
+    }
+    switch f.Dedup {
+    case TicketDedupEarliest, TicketDedupLatest, TicketDedupCurrent:
+    default:
+        return nil, fmt.Errorf("tickets.dedup: unknown rule %q (valid rules: %s, %s, %s)", t.Dedup,
+            TicketDedupEarliest, TicketDedupLatest, TicketDedupCurrent)
+    }
+    return f, nil
+}
+
+// codeSet parses a comma-separated code list, nil if it is empty
+func codeSet(codes string) map[string]bool {
+    var set map[string]bool
+    for _, code := range SplitList(codes) {
+        if set == nil {
+            set = make(map[string]bool)
+        }
+        set[code] = true
+    }
+    return set
+}
+
+// reject returns why the filter rejects a ticket row, "" if it keeps it
+func (f *TicketFilter) reject(t *Ticket) string {
+    switch {
+    case f.CauseCodes != nil && !f.CauseCodes[t.IrptCauseCode]:
+        return rejectCauseCode
+    case f.TypeCodes != nil && !f.TypeCodes[t.IrptTypeCode]:
+        return rejectTypeCode
+    case f.GreenTicket != "" && t.GrnTicketFlag != f.GreenTicket:
+        return rejectGreenTicket
+    }
+    if f.HasMinCMI {
+        if cmi, err := strconv.ParseFloat(t.CMI, 64); err != nil || cmi < f.MinCMI {
+            return rejectCMI
+        }
+    }
+    if (!f.From.IsZero() && t.PowerOff.Before(f.From)) || (!f.To.IsZero() && !t.PowerOff.Before(f.To)) {
+        return rejectDateRange
+    }
+    return ""
+}
+
+// pick returns the index of the row of a ticket that labels signatures, -1 if there is none. The rows are
+// sorted by POWEROFF.
+func (f *TicketFilter) pick(rows []Ticket) int {
+    switch f.Dedup {
+    case TicketDedupLatest:
+        return len(rows) - 1
+    case TicketDedupCurrent:
+        for i := range rows {
+            if rows[i].CurrentRowFlag == "Y" {
+                return i
+            }
+        }
+        return -1
+    }
+    return 0
+}
+
+// TicketReject is a ticket row GetTicketMap did not use
+type TicketReject struct {
+    File      string
+    Line      int
+    TicketKey string
+    Reason    string
+}
+
+// TicketReport counts the ticket rows GetTicketMap read, kept and rejected, and lists the rejected rows
+// and why the bad ones could not be read
+type TicketReport struct {
+    Rows     int
+    Tickets  int
+    Rejected map[string]int // reason -> rows
+    Rejects  []TicketReject
+    BadRows  []*RowError
+}
+
+func (r *TicketReport) add(reject TicketReject) {
+    if r.Rejected == nil {
+        r.Rejected = make(map[string]int)
+    }
+    r.Rejected[reject.Reason]++
+    r.Rejects = append(r.Rejects, reject)
+}
+
+// Format summarizes the report, e.g. {rows: 10, tickets: 3, cause code: 5, duplicate: 2}
+func (r *TicketReport) Format() string {
+    var reasons []string
+    for reason := range r.Rejected {
+        reasons = append(reasons, reason)
+    }
+    sort.Strings(reasons)
+    s := fmt.Sprintf("{rows: %d, tickets: %d", r.Rows, r.Tickets)
+    for _, reason := range reasons {
+        s += fmt.Sprintf(", %s: %d", reason, r.Rejected[reason])
+    }
+    return s + "}"
+}
+
+// WriteRejects writes the rejected rows to fileName as CSV: FILE, LINE, TICKET, REASON
+func (r *TicketReport) WriteRejects(fileName string) error {
+    file, err := createOutputFile(fileName)
+    if err != nil {
+        return err
+    }
+    writer := csv.NewWriter(file)
+    writer.Write([]string{"FILE", "LINE", "TICKET", "REASON"})
+    for _, reject := range r.Rejects {
+        writer.Write([]string{reject.File, strconv.Itoa(reject.Line), reject.TicketKey, reject.Reason})
+    }
+    writer.Flush()
+    if err = writer.Error(); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+    "fmt"
+    "time"
+)
+
+// What a TimeZone does with a local time that daylight saving time makes ambiguous (clocks fall back
+// and it happens twice) or nonexistent (clocks spring forward over it)
+const (
+    AmbiguousEarlier   = "earlier" // the first of the two instants, still in daylight saving time
+    AmbiguousLater     = "later"   // the second of the two instants
+    AmbiguousError     = "error"   // the row is a bad row
+    NonexistentForward = "forward" // shifted forward by the gap, so 02:30 is 03:30 in the new offset
+    NonexistentError   = "error"   // the row is a bad row
+)
+
+// AnomalyTimeFormat is the layout of the Time of the anomaly output, always in UTC
+const AnomalyTimeFormat = time.RFC3339
+
+// TimeZoneConfig holds the time zone the local times of each source are in (an IANA name, e.g.
+// "America/New_York", or "UTC") and the policies for the local times daylight saving time makes
+// ambiguous or nonexistent
+type TimeZoneConfig struct {
+    Edna        string `yaml:"edna"        json:"edna"`
+    Scada       string `yaml:"scada"       json:"scada"`
+    Ami         string `yaml:"ami"         json:"ami"`         // of the times without an offset
+    Tickets     string `yaml:"tickets"     json:"tickets"`
+    Ambiguous   string `yaml:"ambiguous"   json:"ambiguous"`   // earlier, later or error
+    Nonexistent string `yaml:"nonexistent" json:"nonexistent"` // forward or error
+}
+
+// TimeZone parses the local times of a source into instants
+type TimeZone struct {
+    Location    *time.Location
+    Ambiguous   string
+    Nonexistent string
+}
+
+// Zone returns the time zone of a source
+func (t *TimeZoneConfig) Zone(source string) (*TimeZone, error) {
+    var name string
+    switch source {
+    case "edna":
+        name = t.Edna
+    case "scada":
+        name = t.Scada
+    case "ami":
+        name = t.Ami
+    case "tickets":
+        name = t.Tickets
+    default:
+        return nil, fmt.Errorf("unknown source %q", source)
+    }
+    if name == "" {
+        return nil, fmt.Errorf("time_zones.%s is not set", source)
+    }
+    location, err := time.LoadLocation(name)
+    if err != nil {
+        return nil, fmt.Errorf("time_zones.%s: %q is not a time zone", source, name)
+    }
+    switch t.Ambiguous {
+    case AmbiguousEarlier, AmbiguousLater, AmbiguousError:
+    default:
+        return nil, fmt.Errorf("time_zones.ambiguous: unknown policy %q (valid policies: %s, %s, %s)", t.Ambiguous,
+            AmbiguousEarlier, AmbiguousLater, AmbiguousError)
+    }
+    switch t.Nonexistent {
+    case NonexistentForward, NonexistentError:
+    default:
+        return nil, fmt.Errorf("time_zones.nonexistent: unknown policy %q (valid policies: %s, %s)", t.Nonexistent,
+            NonexistentForward, NonexistentError)
+    }
+    return &TimeZone{Location: location, Ambiguous: t.Ambiguous, Nonexistent: t.Nonexistent}, nil
+}
+
+// In returns the instant at which clocks in the time zone show the wall time of wall (its date and
+// clock, whatever its location)
+func (z *TimeZone) In(wall time.Time) (time.Time, error) {
+    naive := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(),
+        wall.Nanosecond(), time.UTC)
+    // the offsets a day either side; no zone changes its offset twice within a day
+    _, before := naive.Add(-24 * time.Hour).In(z.Location).Zone()
+    _, after  := naive.Add(24 * time.Hour).In(z.Location).Zone()
+    var instants []time.Time
+    for _, offset := range []int{before, after} {
+        instant := naive.Add(-time.Duration(offset) * time.Second)
+        if _, actual := instant.In(z.Location).Zone(); actual == offset &&
+            (len(instants) == 0 || !instant.Equal(instants[0])) {
+            instants = append(instants, instant)
+        }
+    }
+    switch {
+    case len(instants) == 0:
+        if z.Nonexistent == NonexistentError {
+            return time.Time{}, fmt.Errorf("does not exist in %s (clocks spring forward)", z.Location)
+        }
+        return naive.Add(-time.Duration(before) * time.Second).UTC(), nil
+    case len(instants) == 2:
+        earlier, later := instants[0], instants[1]
+        if later.Before(earlier) {
+            earlier, later = later, earlier
+        }
+        switch z.Ambiguous {
+        case AmbiguousError:
+            return time.Time{}, fmt.Errorf("is ambiguous in %s (clocks fall back)", z.Location)
+        case AmbiguousLater:
+            return later.UTC(), nil
+        }
+        return earlier.UTC(), nil
+    }
+    return instants[0].UTC(), nil
+}
+
+// formatAnomalyTime formats the time of an anomaly for the output
+func formatAnomalyTime(tm time.Time) string {
+    return tm.UTC().Format(AnomalyTimeFormat)
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+    "testing"
+    "time"
+)
+
+func TestTimeZoneDST(t *testing.T) {
+    cfg := DefaultConfig().TimeZones
+    zone, err := cfg.Zone("scada")
+    if err != nil {
+        t.Skip(err)
+    }
+    tests := []struct {
+        local       string
+        ambiguous   string
+        nonexistent string
+        want        string // "" for an error
+    }{
+        {"2016-07-01 12:00:00", AmbiguousEarlier, NonexistentForward, "2016-07-01T16:00:00Z"},
+        {"2016-12-01 12:00:00", AmbiguousEarlier, NonexistentForward, "2016-12-01T17:00:00Z"},
+        {"2016-11-06 01:30:00", AmbiguousEarlier, NonexistentForward, "2016-11-06T05:30:00Z"},
+        {"2016-11-06 01:30:00", AmbiguousLater, NonexistentForward, "2016-11-06T06:30:00Z"},
+        {"2016-11-06 01:30:00", AmbiguousError, NonexistentForward, ""},
+        {"2016-03-13 02:30:00", AmbiguousEarlier, NonexistentForward, "2016-03-13T07:30:00Z"},
+        {"2016-03-13 02:30:00", AmbiguousEarlier, NonexistentError, ""},
+        {"2016-03-13 03:00:00", AmbiguousEarlier, NonexistentError, "2016-03-13T07:00:00Z"},
+    }
+    for _, test := range tests {
+        zone.Ambiguous, zone.Nonexistent = test.ambiguous, test.nonexistent
+        tm, err := parseTimeField("LOCALTIME", "2006-01-02 15:04:05", test.local, zone, true)
+        if test.want == "" {
+            if err == nil {
+                t.Errorf("%s (%s, %s): got %s, want an error", test.local, test.ambiguous, test.nonexistent, tm)
+            }
+        } else if err != nil || formatAnomalyTime(tm) != test.want {
+            t.Errorf("%s (%s, %s): got %s (%v), want %s", test.local, test.ambiguous, test.nonexistent,
+                formatAnomalyTime(tm), err, test.want)
+        }
+    }
+
+    cfg.Ambiguous = "first"
+    if _, err = cfg.Zone("scada"); err == nil {
+        t.Errorf("ambiguous policy first: got no error")
+    }
+    if tm, _ := parseAnomalyTime("2016-03-13T07:30:00Z"); tm.Unix() != time.Date(2016, 3, 13, 7, 30, 0, 0, time.UTC).Unix() {
+        t.Errorf("anomaly time: got %s", tm)
+    }
+}
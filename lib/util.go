@@ -1,5 +1,18 @@
 package lib
 
+import "strings"
+
+// SplitList splits a comma-separated list (a flag or config value), trimming spaces and dropping empty items
+func SplitList(value string) []string {
+    var items []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
 // Sort arrays of int64
 type int64arr []int64
 
@@ -16,10 +29,17 @@ func (a int64arr) Less(i, j int) bool {
 }
 
 
-// Create a y object
+// Create a y object: Outage is the hours to the outage of Ticket, NaN if none (see AddTarget)
 type YObject struct {
     Feeder    string
     Timestamp int64
-    Outage    int64
+    Outage    float64
     Ticket    string
 }
+
+// Create an x object: one signature row, Values in dataset column order. Sequence columns hold
+// their count in Values and the day offsets of their anomalies in Sequences, by column index.
+type XObject struct {
+    Values    []float64
+    Sequences map[int][]int64
+}
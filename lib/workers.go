@@ -0,0 +1,386 @@
+package lib
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "github.com/aws/aws-sdk-go/service/s3"
+)
+
+// AnomalyCount counts anomalies by type. It is shared by the workers of a run; each file is counted on
+// its own (see fileCount) and added to the count of the run once its output is written.
+type AnomalyCount struct {
+    mu     sync.Mutex
+    counts map[string]int
+}
+
+// NewAnomalyCount starts a count of zero for every anomaly type in names
+func NewAnomalyCount(names map[string]bool) *AnomalyCount {
+    c := &AnomalyCount{counts: make(map[string]int)}
+    for name := range names {
+        c.counts[name] = 0
+    }
+    return c
+}
+
+func (c *AnomalyCount) Inc(name string) {
+    c.add(map[string]int{name: 1})
+}
+
+func (c *AnomalyCount) add(counts map[string]int) {
+    c.mu.Lock()
+    for name, n := range counts {
+        c.counts[name] += n
+    }
+    c.mu.Unlock()
+}
+
+// fileCount returns a count for one input file, starting at zero for the types of c
+func (c *AnomalyCount) fileCount() *AnomalyCount {
+    fc := &AnomalyCount{counts: make(map[string]int)}
+    for name := range c.Counts() {
+        fc.counts[name] = 0
+    }
+    return fc
+}
+
+// Counts returns a copy of the counts
+func (c *AnomalyCount) Counts() map[string]int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    counts := make(map[string]int)
+    for name, n := range c.counts {
+        counts[name] = n
+    }
+    return counts
+}
+
+// Format returns ", NAME: count" for every counted type in include (all types if nil), by name
+func (c *AnomalyCount) Format(include map[string]bool) string {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    var names []string
+    for name := range c.counts {
+        if include == nil || include[name] {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    str := ""
+    for _, name := range names {
+        str += ", " + name + ": " + strconv.Itoa(c.counts[name])
+    }
+    return str
+}
+
+// RunOptions select and schedule the input files of ProcessEDNA, ProcessAMI and ProcessSCADA
+type RunOptions struct {
+    StartFileNumber int  // number of the first input file to process
+    EndFileNumber   int  // number of the last input file to process, < 0 for all
+    Workers         int  // number of input files processed in parallel
+    Resume          bool // skip the input files finished by an earlier run with the same output file
+    Select          InputSelector
+    DryRun          bool // list the selected input files instead of processing them
+    MaxBadRows      int  // bad rows an input file may have before it fails, < 0 for no limit
+}
+
+// inputFile is one numbered input file of an anomaly run. Path is read and Tag names the file in
+// logs; for S3 objects Key is downloaded to Path first and Path removed afterwards. Size and ModTime
+// (RFC 3339, local files) or ETag (S3 objects) identify its version in the run manifest. Month is that
+// of the monthly directory or S3 prefix of the file, if any.
+type inputFile struct {
+    Num     int
+    Path    string
+    Tag     string
+    Key     string
+    Size    int64
+    ModTime string
+    ETag    string
+    Month   string
+}
+
+// selectFiles keeps the files numbered from options.StartFileNumber to options.EndFileNumber (< 0 for no
+// end) that options.Select selects
+func selectFiles(files []inputFile, options RunOptions) []inputFile {
+    var selected []inputFile
+    for _, file := range files {
+        if file.Num >= options.StartFileNumber && (options.EndFileNumber < 0 || file.Num <= options.EndFileNumber) &&
+            options.Select.selects(file) {
+            selected = append(selected, file)
+        }
+    }
+    return selected
+}
+
+// dirFiles numbers the files of dir from fileNum on, only .csv files if csvOnly
+func dirFiles(dir string, fileNum int, csvOnly bool) []inputFile {
+    var files []inputFile
+    entries, _ := ioutil.ReadDir(dir)
+    for _, f   := range entries {
+        if csvOnly && !strings.Contains(f.Name(), ".csv") {
+            continue
+        }
+        filePath := dir + "/" + f.Name()
+        files = append(files, inputFile{Num: fileNum, Path: filePath, Tag: filePath, Size: f.Size(),
+            ModTime: f.ModTime().UTC().Format(time.RFC3339Nano)})
+        fileNum++
+    }
+    return files
+}
+
+// monthlyFiles numbers the .csv files of every monthly directory under dir
+func monthlyFiles(dir string) []inputFile {
+    var files []inputFile
+    dirs, _  := ioutil.ReadDir(dir)
+    for _, d := range dirs {
+        monthFiles := dirFiles(dir + "/" + d.Name(), len(files), true)
+        for i := range monthFiles {
+            monthFiles[i].Month = directoryMonth(d.Name())
+        }
+        files = append(files, monthFiles...)
+    }
+    return files
+}
+
+// awsFiles numbers the S3 objects, downloaded to current_file_<start>_<end>_<number>.csv in the output dir
+func awsFiles(cfg *Config, objects []AWSObject, startFileNumber int, endFileNumber int) []inputFile {
+    var files []inputFile
+    for fileNum, object := range objects {
+        filePath := cfg.OutputPath("current_file_" + strconv.Itoa(startFileNumber) + "_" + strconv.Itoa(endFileNumber) + "_" + strconv.Itoa(fileNum) + ".csv")
+        files = append(files, inputFile{Num: fileNum, Path: filePath, Tag: object.Key, Key: object.Key, Size: object.Size, ETag: object.ETag,
+            Month: directoryMonth(path.Dir(object.Key))})
+    }
+    return files
+}
+
+// runFiles processes the input files selected by options into ofileName and records each finished file in
+// the manifest of ofileName. The rows each file skipped are written to the quarantine file of ofileName.
+// A file that cannot be read, or has more than options.MaxBadRows bad rows, fails: its output is dropped,
+// the other files are processed, and runFiles returns an error at the end. With options.Resume, the files
+// the manifest lists (while they are unchanged) are skipped and the output after them, from an interrupted
+// or failed file, is truncated. With options.DryRun, the selected files are only listed.
+func runFiles(ofileName string, files []inputFile, options RunOptions, svc *s3.S3, bucket string, counts *AnomalyCount,
+    process func(file inputFile, writer *bufio.Writer, counts *AnomalyCount, bad *BadRows) error) error {
+    files = selectFiles(files, options)
+    if options.DryRun {
+        for _, file := range files {
+            fmt.Printf("%d\t%s\n", file.Num, file.Tag)
+        }
+        fmt.Printf("%d input files selected\n", len(files))
+        return nil
+    }
+
+    var entries []ManifestEntry
+    if options.Resume {
+        var err error
+        if entries, err = readManifest(manifestPath(ofileName)); err != nil {
+            return err
+        }
+    }
+    done, offset := resumeFiles(entries, files)
+    if info, err := os.Stat(ofileName); done > 0 && (err != nil || info.Size() < offset) {
+        fmt.Printf("%s is missing or shorter than its manifest, not resuming\n", ofileName)
+        done, offset = 0, 0
+    }
+    entries = entries[:done]
+    quarantineOffset := int64(0)
+    if done > 0 {
+        quarantineOffset = entries[done - 1].Quarantine
+    }
+    if info, err := os.Stat(quarantinePath(ofileName)); quarantineOffset > 0 && (err != nil || info.Size() < quarantineOffset) {
+        fmt.Printf("%s is missing or shorter than its manifest, starting it again\n", quarantinePath(ofileName))
+        quarantineOffset = 0
+    }
+    for _, entry := range entries {
+        counts.add(entry.Counts)
+    }
+    if done > 0 {
+        fmt.Printf("Resuming after %d finished input files (%d bytes of output)\n", done, offset)
+    }
+
+    ofile, err := openOutputFile(ofileName, offset)
+    if err != nil {
+        return err
+    }
+    defer ofile.Close()
+    qfile, quarantineOffset, err := openQuarantineFile(quarantinePath(ofileName), quarantineOffset)
+    if err != nil {
+        return err
+    }
+    defer qfile.Close()
+    manifest, err := createManifest(manifestPath(ofileName), entries)
+    if err != nil {
+        return err
+    }
+    defer manifest.Close()
+
+    // files with bad rows or errors, for the summary
+    var summary []string
+    badRows := 0
+    for _, entry := range entries {
+        badRows += entry.BadRows
+        if entry.BadRows > 0 {
+            summary = append(summary, fmt.Sprintf("{id: %d, input: \"%s\", badRows: %d}", entry.Num, entry.Input, entry.BadRows))
+        }
+    }
+    failed := 0
+
+    writer := bufio.NewWriter(ofile)
+    processFiles(files[done:], options.Workers, options.MaxBadRows, svc, bucket, counts, process,
+        func(file inputFile, out fileOutput) {
+            if len(out.bad) > 0 {
+                quarantine := formatQuarantine(out.bad)
+                if _, err := qfile.Write(quarantine); err != nil {
+                    log.Fatalf("writing bad rows of %s: %v", file.Tag, err)
+                }
+                quarantineOffset += int64(len(quarantine))
+                badRows += len(out.bad)
+            }
+            if out.err != nil {
+                failed++
+                fmt.Printf("%d\t%s failed: %v\n", file.Num, file.Tag, out.err)
+                summary = append(summary, fmt.Sprintf("{id: %d, input: \"%s\", badRows: %d, error: %q}", file.Num, file.Tag,
+                    len(out.bad), out.err))
+                return
+            }
+            if len(out.bad) > 0 {
+                summary = append(summary, fmt.Sprintf("{id: %d, input: \"%s\", badRows: %d}", file.Num, file.Tag, len(out.bad)))
+            }
+            if _, err := writer.Write(out.data); err != nil {
+                log.Fatalf("writing output of %s: %v", file.Tag, err)
+            }
+            if err := writer.Flush(); err != nil {
+                log.Fatalf("writing output of %s: %v", file.Tag, err)
+            }
+            offset += int64(len(out.data))
+            counts.add(out.counts)
+            entry := newManifestEntry(file, offset, out.counts)
+            entry.BadRows, entry.Quarantine = len(out.bad), quarantineOffset
+            if err := manifest.add(entry); err != nil {
+                log.Fatal(err)
+            }
+        })
+    for _, line := range summary {
+        fmt.Println(line)
+    }
+    fmt.Printf("{files: %d, resumed: %d, failed: %d, badRows: %d%s}\n", len(files), done, failed, badRows, counts.Format(nil))
+    if badRows > 0 {
+        fmt.Printf("Bad rows are in %s\n", quarantinePath(ofileName))
+    }
+    if failed > 0 {
+        return fmt.Errorf("%d of %d input files failed", failed, len(files))
+    }
+    return nil
+}
+
+// openOutputFile creates fileName, or with offset > 0 truncates the existing file to offset to append to it
+func openOutputFile(fileName string, offset int64) (*os.File, error) {
+    if offset == 0 {
+        return createOutputFile(fileName)
+    }
+    file, err := os.OpenFile(fileName, os.O_WRONLY, 0644)
+    if err != nil {
+        return nil, err
+    }
+    if err = file.Truncate(offset); err == nil {
+        _, err = file.Seek(offset, io.SeekStart)
+    }
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    return file, nil
+}
+
+type fileOutput struct {
+    index  int
+    data   []byte
+    counts map[string]int
+    bad    []*RowError
+    err    error // the file failed
+}
+
+// processFiles runs process on the files with a pool of workers. Each file is processed into its own
+// buffer with its own count and bad rows (at most maxBadRows of them, < 0 for no limit), and output is
+// called with the buffers in file order, so the output is that of a serial run. A file is not started
+// until the file fileLookahead(workers) before it is written, so a slow file holds at most that many
+// buffers in memory.
+func processFiles(files []inputFile, workers int, maxBadRows int, svc *s3.S3, bucket string, counts *AnomalyCount,
+    process func(file inputFile, writer *bufio.Writer, counts *AnomalyCount, bad *BadRows) error,
+    output func(file inputFile, out fileOutput)) {
+    if workers < 1 {
+        workers = 1
+    }
+    jobs    := make(chan int)
+    outputs := make(chan fileOutput, workers)
+    slots   := make(chan struct{}, fileLookahead(workers)) // files started and not yet written
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for index := range jobs {
+                file := files[index]
+                if file.Key != "" {
+                    if err := GetAWSFile(svc, bucket, file.Key, file.Path); err != nil {
+                        outputs <- fileOutput{index: index, err: err}
+                        continue
+                    }
+                }
+                var buf bytes.Buffer
+                fileWriter := bufio.NewWriter(&buf)
+                fileCounts := counts.fileCount()
+                bad        := NewBadRows(maxBadRows)
+                err        := process(file, fileWriter, fileCounts, bad)
+                fileWriter.Flush()
+                if file.Key != "" {
+                    os.Remove(file.Path)
+                    // name the S3 object rather than its download in the quarantine
+                    for _, row := range bad.Rows {
+                        if row.File == file.Path {
+                            row.File = file.Key
+                        }
+                    }
+                }
+                outputs <- fileOutput{index, buf.Bytes(), fileCounts.Counts(), bad.Rows, err}
+            }
+        }()
+    }
+    go func() {
+        for index := range files {
+            slots <- struct{}{}
+            jobs  <- index
+        }
+        close(jobs)
+        wg.Wait()
+        close(outputs)
+    }()
+
+    // write the outputs in file order
+    pending := make(map[int]fileOutput)
+    next    := 0
+    for out := range outputs {
+        pending[out.index] = out
+        for out, ok := pending[next]; ok; out, ok = pending[next] {
+            output(files[next], out)
+            delete(pending, next)
+            next++
+            <-slots
+        }
+    }
+}
+
+// fileLookahead returns the number of files processFiles keeps started and not yet written
+func fileLookahead(workers int) int {
+    return 2 * workers
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+    "bufio"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestProcessFilesLookahead(t *testing.T) {
+    const workers = 2
+    var files []inputFile
+    for i := 0; i < 20; i++ {
+        files = append(files, inputFile{Num: i, Path: strconv.Itoa(i), Tag: strconv.Itoa(i)})
+    }
+    release := make(chan struct{})
+    var mu sync.Mutex
+    started := 0
+    process := func(file inputFile, writer *bufio.Writer, counts *AnomalyCount, bad *BadRows) error {
+        mu.Lock()
+        started++
+        mu.Unlock()
+        if file.Num == 0 {
+            <-release // the first file is slow
+        }
+        writer.WriteString(file.Tag + "\n")
+        return nil
+    }
+    go func() {
+        time.Sleep(100 * time.Millisecond)
+        mu.Lock()
+        if started > fileLookahead(workers) {
+            t.Errorf("%d files started while the first one runs, want at most %d", started, fileLookahead(workers))
+        }
+        mu.Unlock()
+        close(release)
+    }()
+
+    var written []string
+    processFiles(files, workers, -1, nil, "", NewAnomalyCount(nil), process, func(file inputFile, out fileOutput) {
+        written = append(written, string(out.data))
+    })
+    if len(written) != len(files) {
+        t.Fatalf("got %d outputs, want %d", len(written), len(files))
+    }
+    for i, data := range written {
+        if data != strconv.Itoa(i) + "\n" {
+            t.Errorf("output %d: got %q", i, data)
+        }
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "fmt"
+    "pam/alert"
+    "pam/lib"
+    "time"
+)
+
+func runAlert(args []string) error {
+    fs          := newFlagSet("alert", "pam alert -model <file> | -predictions <file> [flags]")
+    alertConfig := fs.String("alert-config", "", "alert levels file (default <data-dir>/pam_<dataset-version>_alert.yaml)")
+    modelId     := fs.String("model-id", "", "model ID reported with each alert (default pam_<dataset-version>)")
+    processed   := fs.String("processed", "", "anomalies of earlier runs that still count in the signature windows")
+    model       := fs.String("model", "", "logistic or tree ensemble model exported to JSON, scoring the signatures")
+    predictions := fs.String("predictions", "", "FEEDER,TIMESTAMP,PROB predictions of an external model, instead of -model")
+    oldPreds    := fs.String("old-predictions", "", "predictions of earlier runs, to avoid sending an alert twice")
+    htmlTmpl    := fs.String("html-template", "", "html/template file replacing the default alert e-mail layout")
+    start       := fs.String("start", "", "earliest signature time to alert on, \""+lib.SignatureTimeFormat+"\" UTC")
+    end         := fs.String("end", "", "ignore anomalies from this time on, \""+lib.SignatureTimeFormat+"\" UTC")
+    config      := addConfigFlags(fs, "alert")
+    if err := parseFlags(fs, args); err != nil {
+        return err
+    }
+    if (*model == "") == (*predictions == "") {
+        fs.Usage()
+        return usageError{"alert: give one of -model and -predictions"}
+    }
+    options := alert.Options{
+        ConfigFile:         *alertConfig,
+        ModelId:            *modelId,
+        ProcessedFile:      *processed,
+        ModelFile:          *model,
+        PredictionsFile:    *predictions,
+        OldPredictionsFile: *oldPreds,
+        HTMLTemplate:       *htmlTmpl,
+    }
+    var err error
+    if options.StartTime, err = parseTimeFlag("start", *start); err != nil {
+        return err
+    }
+    if options.EndTime, err = parseTimeFlag("end", *end); err != nil {
+        return err
+    }
+    cfg, err := config.load()
+    if err != nil {
+        return err
+    }
+
+    alerts, err := alert.ProcessAlerts(cfg, options)
+    if err != nil {
+        return err
+    }
+    for _, a := range alerts {
+        fmt.Println(a.String())
+    }
+    fmt.Printf("%d alerts\n", len(alerts))
+    return nil
+}
+
+// parseTimeFlag returns the epoch of a time flag, 0 if it is empty
+func parseTimeFlag(name string, value string) (int64, error) {
+    if value == "" {
+        return 0, nil
+    }
+    tm, err := time.Parse(lib.SignatureTimeFormat, value)
+    if err != nil {
+        return 0, usageError{fmt.Sprintf("alert: -%s: %v", name, err)}
+    }
+    return tm.Unix(), nil
+}
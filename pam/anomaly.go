@@ -0,0 +1,148 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "runtime"
+    "strings"
+    "pam/lib"
+)
+
+var anomalySources = []string{"edna", "scada", "ami", "tickets"}
+
+func runAnomaly(args []string) error {
+    if len(args) < 1 {
+        anomalyUsage()
+        return usageError{"anomaly: missing source"}
+    }
+    source := args[0]
+    switch source {
+    case "-h", "-help", "--help":
+        anomalyUsage()
+        return nil
+    case "edna", "scada", "ami", "tickets":
+    default:
+        anomalyUsage()
+        return usageError{fmt.Sprintf("anomaly: unknown source %q", source)}
+    }
+
+    fs      := newFlagSet("anomaly "+source, "pam anomaly "+source+" [flags]")
+    start   := fs.Int("start", 0, "number of the first input file to process")
+    end     := fs.Int("end", -1, "number of the last input file to process (-1 for all)")
+    workers := fs.Int("workers", runtime.NumCPU(), "number of input files to process in parallel")
+    resume  := fs.Bool("resume", false, "skip the input files finished by an interrupted run (see the output manifest)")
+    isBulk, isLocal := new(bool), new(bool)
+    if source == "edna" || source == "ami" {
+        isBulk  = fs.Bool("bulk", true, "read bulk (true) or monthly (false) data")
+        isLocal = fs.Bool("local", true, "read monthly data from local disk (true) or AWS S3 (false)")
+    }
+    anomalies := fs.String("anomalies", "", "anomalies to extract: comma-separated names and the sets default and all (default: anomalies."+source+" from the run config)")
+    selector  := addSelectorFlags(fs, source == "edna" || source == "ami")
+    dryRun    := fs.Bool("dry-run", false, "list the selected input files and exit")
+    order     := new(string)
+    if source == "edna" {
+        order = fs.String("order", lib.EdnaOrderAuto, "time order of the input files: auto (check each file, reading it twice), sorted (stream each file once) or unsorted (external sort)")
+    }
+    config := addConfigFlags(fs, "anomaly "+source)
+    if err := parseFlags(fs, args[1:]); err != nil {
+        return err
+    }
+    if source == "edna" && !lib.ValidEdnaOrder(*order) {
+        return usageError{fmt.Sprintf("anomaly edna: unknown -order %q (valid orders: auto, sorted, unsorted)", *order)}
+    }
+    sel, err := selector.load()
+    if err != nil {
+        return err
+    }
+    if sel.HasMonths() && *isBulk {
+        return usageError{fmt.Sprintf("anomaly %s: -from-month and -to-month select monthly data (-bulk=false)", source)}
+    }
+    cfg, err := config.load()
+    if err != nil {
+        return err
+    }
+    if *anomalies != "" {
+        cfg.Set("anomalies."+source, *anomalies)
+    }
+    processAnomaly, err := lib.SelectAnomalies(source, cfg.Anomalies.Source(source))
+    if err != nil {
+        return usageError{"anomaly: " + err.Error()}
+    }
+    if missing := lib.FcNoBoMissing(processAnomaly); len(missing) > 0 {
+        return usageError{"anomaly: FC_NO_BO is found from the breaker opens and fault currents in the output; select " +
+            strings.Join(missing, " and ") + " too"}
+    }
+    maxBadRows, err := cfg.BadRowLimit()
+    if err != nil {
+        return usageError{"anomaly: " + err.Error()}
+    }
+    if err = cfg.CheckAnomalyConfig(source, *isBulk, *isLocal); err != nil {
+        return usageError{"anomaly: " + err.Error()}
+    }
+
+    fmt.Printf("source=%s start=%d end=%d bulk=%v local=%v workers=%d resume=%v\n", source, *start, *end, *isBulk, *isLocal, *workers, *resume)
+    options := lib.RunOptions{StartFileNumber: *start, EndFileNumber: *end, Workers: *workers, Resume: *resume,
+        Select: sel, DryRun: *dryRun, MaxBadRows: maxBadRows}
+    switch source {
+    case "edna":
+        return lib.ProcessEDNA(cfg, options, *isBulk, *isLocal, *order)
+    case "ami":
+        return lib.ProcessAMI(cfg, options, *isBulk, *isLocal)
+    case "scada":
+        return lib.ProcessSCADA(cfg, options)
+    }
+    return lib.ProcessTickets(cfg, options)
+}
+
+func anomalyUsage() {
+    fmt.Fprintf(os.Stderr, "Usage: pam anomaly <source> [flags]\n\nSources: %v\n\nRun 'pam anomaly <source> -h' for the flags of a source.\n", anomalySources)
+}
+
+// selectorFlags are the flags selecting input files by name, feeder and month
+type selectorFlags struct {
+    match      *string
+    feeders    *string
+    feederFile *string
+    fromMonth  *string
+    toMonth    *string
+}
+
+// addSelectorFlags registers -match, -feeders and -feeder-file, and -from-month and -to-month for sources
+// with monthly data
+func addSelectorFlags(fs *flag.FlagSet, months bool) *selectorFlags {
+    s := &selectorFlags{
+        match:      fs.String("match", "", "comma-separated glob patterns of the input file names (or paths/S3 keys if they contain a /)"),
+        feeders:    fs.String("feeders", "", "comma-separated feeder IDs of the input files to process"),
+        feederFile: fs.String("feeder-file", "", "file of feeder IDs to process, one per line"),
+        fromMonth:  new(string),
+        toMonth:    new(string),
+    }
+    if months {
+        s.fromMonth = fs.String("from-month", "", "first month (YYYY-MM) of the monthly directories to process")
+        s.toMonth   = fs.String("to-month", "", "last month (YYYY-MM) of the monthly directories to process")
+    }
+    return s
+}
+
+func (s *selectorFlags) load() (lib.InputSelector, error) {
+    sel := lib.InputSelector{Patterns: lib.SplitList(*s.match), FromMonth: *s.fromMonth, ToMonth: *s.toMonth}
+    feeders := lib.SplitList(*s.feeders)
+    if *s.feederFile != "" {
+        fileFeeders, err := lib.ReadFeederList(*s.feederFile)
+        if err != nil {
+            return sel, err
+        }
+        feeders = append(feeders, fileFeeders...)
+    }
+    if len(feeders) > 0 {
+        sel.Feeders = make(map[string]bool)
+        for _, feeder := range feeders {
+            sel.Feeders[feeder] = true
+        }
+    }
+    if err := sel.Validate(); err != nil {
+        return sel, usageError{"anomaly: " + err.Error()}
+    }
+    return sel, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "pam/lib"
+)
+
+func runCompare(args []string) error {
+    fs      := newFlagSet("compare", "pam compare -old <python anomalies> -new <go anomalies>")
+    oldFile := fs.String("old", "", "anomaly file produced by the Python pipeline")
+    newFile := fs.String("new", "", "anomaly file produced by the Go pipeline")
+    config  := addConfigFlags(fs, "compare")
+    if err := parseFlags(fs, args); err != nil {
+        return err
+    }
+    if *oldFile == "" || *newFile == "" {
+        fs.Usage()
+        return usageError{"compare: -old and -new are required"}
+    }
+    maxBadRows, err := loadBadRowLimit(config, "compare")
+    if err != nil {
+        return err
+    }
+    return lib.CompareAllAnomsWithEDNAAnoms(*oldFile, *newFile, maxBadRows)
+}
+
+func runMerge(args []string) error {
+    fs       := newFlagSet("merge", "pam merge -new <path> -old <path> [flags]")
+    newPath  := fs.String("new", "", "new anomaly file path without extension")
+    newExt   := fs.String("new-ext", ".csv", "new anomaly file extension")
+    oldPath  := fs.String("old", "", "old anomaly file path without extension")
+    oldExt   := fs.String("old-ext", ".csv", "old anomaly file extension")
+    config   := addConfigFlags(fs, "merge")
+    if err := parseFlags(fs, args); err != nil {
+        return err
+    }
+    if *newPath == "" || *oldPath == "" {
+        fs.Usage()
+        return usageError{"merge: -new and -old are required"}
+    }
+    maxBadRows, err := loadBadRowLimit(config, "merge")
+    if err != nil {
+        return err
+    }
+    return lib.SortMergeAnomalyFile(*newPath, *newExt, *oldPath, *oldExt, maxBadRows)
+}
+
+// loadBadRowLimit reads the run config and returns its max_bad_rows
+func loadBadRowLimit(config *configFlags, command string) (int, error) {
+    cfg, err := config.load()
+    if err != nil {
+        return 0, err
+    }
+    maxBadRows, err := cfg.BadRowLimit()
+    if err != nil {
+        return 0, usageError{command + ": " + err.Error()}
+    }
+    return maxBadRows, nil
+}
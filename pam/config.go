@@ -0,0 +1,86 @@
+package main
+
+import (
+    "flag"
+    "pam/lib"
+    "strings"
+)
+
+// configFlags holds the -config flag and one override flag per run config key
+type configFlags struct {
+    fs        *flag.FlagSet
+    file      *string
+    overrides map[string]*string // flag name -> value
+    keys      map[string]string  // flag name -> config key
+}
+
+// The run config keys each command reads, in lib.ConfigKeys order. The anomalies.* keys are set by the
+// -anomalies flag of pam anomaly instead.
+var (
+    s3ConfigKeys      = []string{"input.bulk_root", "input.monthly_root", "input.s3_bucket", "input.s3_region", "input.s3_profile"}
+    zoneConfigKeys    = []string{"time_zones.ambiguous", "time_zones.nonexistent"}
+    commandConfigKeys = map[string][]string{
+        "anomaly edna": concatKeys(s3ConfigKeys, []string{"output_dir", "data_dir", "edna_rules_version", "max_bad_rows",
+            "time_zones.edna"}, zoneConfigKeys),
+        "anomaly ami": concatKeys(s3ConfigKeys, []string{"output_dir", "feeder_metadata", "max_bad_rows",
+            "time_zones.ami"}, zoneConfigKeys),
+        "anomaly scada": concatKeys([]string{"input.bulk_root", "output_dir", "max_bad_rows", "scada.fc_no_bo_before",
+            "scada.fc_no_bo_after", "time_zones.scada"}, zoneConfigKeys),
+        "anomaly tickets": concatKeys([]string{"input.tickets_dir", "output_dir", "max_bad_rows", "time_zones.tickets"},
+            zoneConfigKeys),
+        "signature": concatKeys([]string{"input.tickets_dir", "input.anomalies_file", "output_dir", "feeder_metadata",
+            "data_dir", "dataset_version", "anomaly_map_version", "max_bad_rows", "tickets.cause_codes",
+            "tickets.type_codes", "tickets.green_ticket", "tickets.min_cmi", "tickets.from_date", "tickets.to_date",
+            "tickets.dedup", "time_zones.tickets"}, zoneConfigKeys),
+        "alert": {"input.anomalies_file", "output_dir", "feeder_metadata", "data_dir", "dataset_version",
+            "anomaly_map_version", "max_bad_rows"},
+        "compare": {"max_bad_rows"},
+        "merge":   {"max_bad_rows"},
+    }
+)
+
+func concatKeys(lists ...[]string) []string {
+    var keys []string
+    for _, list := range lists {
+        keys = append(keys, list...)
+    }
+    return keys
+}
+
+// addConfigFlags registers -config and an override flag for each run config key the command reads
+// (input.bulk_root becomes -bulk-root, scada.fc_no_bo_after -fc-no-bo-after, tickets.min_cmi
+// -tickets-min-cmi). The other keys can only be set in the config file.
+func addConfigFlags(fs *flag.FlagSet, command string) *configFlags {
+    c := &configFlags{fs: fs, overrides: make(map[string]*string), keys: make(map[string]string)}
+    c.file = fs.String("config", "", "run config file (YAML, or JSON with a .json extension)")
+    for _, key := range commandConfigKeys[command] {
+        name := strings.NewReplacer(".", "-", "_", "-").Replace(strings.TrimPrefix(strings.TrimPrefix(key, "input."), "scada."))
+        c.overrides[name] = fs.String(name, "", "override "+key+" from the run config")
+        c.keys[name] = key
+    }
+    return c
+}
+
+// load reads the config file (or the defaults) and applies the flags that were set. A flag value Set
+// rejects is a usage error.
+func (c *configFlags) load() (*lib.Config, error) {
+    cfg := lib.DefaultConfig()
+    if *c.file != "" {
+        var err error
+        if cfg, err = lib.LoadConfig(*c.file); err != nil {
+            return nil, err
+        }
+    }
+    var err error
+    c.fs.Visit(func(f *flag.Flag) {
+        if key, ok := c.keys[f.Name]; ok && err == nil {
+            if setErr := cfg.Set(key, *c.overrides[f.Name]); setErr != nil {
+                err = usageError{"-" + f.Name + ": " + setErr.Error()}
+            }
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+    return cfg, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+)
+
+// A command is one pipeline stage exposed as `pam <name>`
+type command struct {
+    name    string
+    summary string
+    run     func(args []string) error
+}
+
+var commands = map[string]command{
+    "anomaly":   {"anomaly",   "extract anomalies from edna, scada, ami or ticket data", runAnomaly},
+    "signature": {"signature", "transform anomalies into signatures",                    runSignature},
+    "compare":   {"compare",   "compare Python anomalies with Go anomalies",             runCompare},
+    "merge":     {"merge",     "sort and merge a new anomaly file with an old one",       runMerge},
+    "alert":     {"alert",     "generate Red/Orange/Yellow feeder alerts",               runAlert},
+}
+
+// usageError is returned for bad command lines; it maps to exit code 2
+type usageError struct {
+    msg string
+}
+
+func (e usageError) Error() string {
+    return e.msg
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+        os.Exit(2)
+    }
+    name := os.Args[1]
+    if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+        usage()
+        os.Exit(0)
+    }
+    cmd, ok := commands[name]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "pam: unknown command %q\n\n", name)
+        usage()
+        os.Exit(2)
+    }
+    os.Exit(exitCode(cmd.run(os.Args[2:])))
+}
+
+func usage() {
+    fmt.Fprintf(os.Stderr, "Usage: pam <command> [flags]\n\nCommands:\n")
+    var names []string
+    for name := range commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+    }
+    fmt.Fprintf(os.Stderr, "\nRun 'pam <command> -h' for the flags of a command.\n")
+}
+
+// exitCode reports err on stderr and returns 0 (ok/help), 2 (usage) or 1 (failure)
+func exitCode(err error) int {
+    if err == nil || err == flag.ErrHelp {
+        return 0
+    }
+    if err.Error() != "" {
+        fmt.Fprintf(os.Stderr, "pam: %s\n", err)
+    }
+    if _, ok := err.(usageError); ok {
+        return 2
+    }
+    return 1
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting
+func newFlagSet(name string, synopsis string) *flag.FlagSet {
+    fs := flag.NewFlagSet(name, flag.ContinueOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(os.Stderr, "Usage: %s\n\nFlags:\n", synopsis)
+        fs.PrintDefaults()
+    }
+    return fs
+}
+
+// parseFlags parses args and turns flag errors and stray arguments into usage errors
+func parseFlags(fs *flag.FlagSet, args []string) error {
+    if err := fs.Parse(args); err != nil {
+        if err == flag.ErrHelp {
+            return err
+        }
+        return usageError{} // already reported by the flag package
+    }
+    if fs.NArg() > 0 {
+        fs.Usage()
+        return usageError{fmt.Sprintf("%s: unexpected arguments %v", fs.Name(), fs.Args())}
+    }
+    return nil
+}
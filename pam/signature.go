@@ -0,0 +1,23 @@
+package main
+
+import (
+    "pam/lib"
+)
+
+func runSignature(args []string) error {
+    fs           := newFlagSet("signature", "pam signature [flags]")
+    maxLookahead := fs.Float64("max-lookahead", 360, "label rows up to this many hours before an outage")
+    maxLookback  := fs.Float64("max-lookback", 0, "label rows up to this many hours after an outage (negative targets)")
+    config       := addConfigFlags(fs, "signature")
+    if err := parseFlags(fs, args); err != nil {
+        return err
+    }
+    cfg, err := config.load()
+    if err != nil {
+        return err
+    }
+    if err = cfg.CheckSignatureConfig(); err != nil {
+        return usageError{"signature: " + err.Error()}
+    }
+    return lib.ProcessSignature(cfg, *maxLookahead, *maxLookback)
+}
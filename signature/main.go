@@ -1,11 +0,0 @@
-package main
-
-import (
-    "pam/lib"
-)
-
-
-func main() {
-    lib.ProcessSignature()
-}
-